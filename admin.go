@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+/*
+	to pull a consistent snapshot of the DB while the server is running (local storage only):
+		curl -o annotations.db "localhost:9119/admin/backup"
+	and to restore it, start the server with:
+		./prom_annotation_server --storage=local:/tmp/annotations.db --restore-from=annotations.db
+	the JSON-lines file can be compacted to the current annotations while the server is running:
+		curl -XPOST "localhost:9119/admin/compact"
+*/
+
+func (s *ServerContext) admin(w http.ResponseWriter, req *http.Request) {
+
+	switch strings.TrimPrefix(req.URL.Path, *adminEndpoint) {
+	case "/backup":
+		s.backup(w, req)
+	case "/compact":
+		s.compact(w, req)
+	default:
+		http.Error(w, "Not found", 404)
+	}
+}
+
+func (s *ServerContext) backup(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != "GET" {
+		http.Error(w, "Not supported", 405)
+		return
+	}
+
+	b, ok := s.storage.(Backuper)
+	if !ok {
+		http.Error(w, "Backups are not supported by this storage", 501)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="annotations.db"`)
+	n, err := b.Backup(w)
+	if err != nil {
+		// the status code is sent already, all we can do is log it
+		log.Printf("backup err after %d bytes: %s", n, err)
+		return
+	}
+	log.Printf("Backup done, %d bytes", n)
+}
+
+func (s *ServerContext) compact(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != "POST" {
+		http.Error(w, "Not supported", 405)
+		return
+	}
+
+	c, ok := s.storage.(Compacter)
+	if !ok {
+		http.Error(w, "Compaction is not supported by this storage", 501)
+		return
+	}
+	if err := c.Compact(); err != nil {
+		log.Printf("compact err: %s", err)
+		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+		return
+	}
+	writeJSON(w, 200, map[string]string{"result": "ok"})
+}
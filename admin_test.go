@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+func (s *TestSetup) testBackup() {
+	ts := int(time.Now().Unix())
+	s.put("msg1", "backup1", ts)
+
+	res, err := http.Get(s.Server.URL + *adminEndpoint + "/backup")
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if _, ok := s.Ctx.storage.(Backuper); !ok {
+		if res.StatusCode != 501 {
+			s.T.Errorf("Expected code of 501, not: %d", res.StatusCode)
+		}
+		return
+	}
+	if res.StatusCode != 200 {
+		s.T.Errorf("Expected code of 200, not: %d", res.StatusCode)
+		return
+	}
+
+	fName := fmt.Sprintf("./test-backup-%d.db", ts)
+	f, _ := os.Create(fName)
+	io.Copy(f, res.Body)
+	f.Close()
+
+	// restore the snapshot into a new DB file to check it's complete
+	restored := fmt.Sprintf("./test-restored-%d.db", ts)
+	defer os.Remove(fName)
+	if err := RestoreBoltDB(fName, restored); err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	b, err := NewBoltDBStorage(restored)
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	defer b.Cleanup()
+
+	if c := b.GetCount("backup1"); c != 1 {
+		s.T.Errorf("wrong count in backup: %d", c)
+	}
+}
+
+func (s *TestSetup) testCompact() {
+	res, err := http.Post(s.Server.URL+*adminEndpoint+"/compact", "", nil)
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	res.Body.Close()
+
+	expected := 200
+	if _, ok := s.Ctx.storage.(Compacter); !ok {
+		expected = 501
+	}
+	if res.StatusCode != expected {
+		s.T.Errorf("Expected code of %d, not: %d", expected, res.StatusCode)
+	}
+}
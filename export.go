@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+	export all annotations as newline-delimited JSON, optionally filtered by tags and the time range they overlap:
+		curl "localhost:9119/annotations/export?tags[]=build&from=1430797000&until=1430798000" > annotations.ndjson
+	and load them again, e.g. into another server:
+		curl -XPOST --data-binary @annotations.ndjson "localhost:9119/annotations/import"
+	timestamps in the export are in seconds, imports take seconds or milliseconds just like PUT requests.
+*/
+
+func hasAnyTag(a Annotation, tags map[string]bool) bool {
+	for _, t := range a.Tags {
+		if tags[t] {
+			return true
+		}
+	}
+	return false
+}
+
+// exportBatch is the number of annotations read from the storage at once
+const exportBatch = 500
+
+func (s *ServerContext) export(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != "GET" {
+		http.Error(w, "Not supported", 405)
+		return
+	}
+
+	req.ParseForm()
+	// EpochSeconds accepts nothing later
+	from, until := 0, int(1e10)-1
+	var err error
+	if v := req.Form.Get("from"); v != "" {
+		from, err = ParseTime(v, time.Now())
+	}
+	if v := req.Form.Get("until"); v != "" && err == nil {
+		until, err = ParseTime(v, time.Now())
+	}
+	if err == nil && from > until {
+		err = fmt.Errorf("from has to be before until")
+	}
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"result": "invalid_time", "error": err.Error()})
+		return
+	}
+	tags := make(map[string]bool)
+	for _, t := range req.Form["tags[]"] {
+		tags[t] = true
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	count := 0
+	opts := ListOpts{Limit: exportBatch}
+	for {
+		var list []Annotation
+		if err = s.storage.List(until-from, until, opts, &list); err != nil || len(list) == 0 {
+			break
+		}
+		for _, a := range list {
+			if len(tags) > 0 && !hasAnyTag(a, tags) {
+				continue
+			}
+			// exports are in seconds like PUT requests
+			a.CreatedAt, a.EndsAt = a.CreatedAt/1000, a.EndsAt/1000
+			if err = enc.Encode(a); err != nil {
+				break
+			}
+			count++
+		}
+		if err != nil {
+			break
+		}
+		last := list[len(list)-1]
+		opts.After = &Cursor{CreatedAt: last.CreatedAt / 1000, ID: last.ID}
+	}
+
+	// the status code is sent already, all we can do is log it
+	if err != nil {
+		log.Printf("export err after %d annotations: %s", count, err)
+	}
+}
+
+func (s *ServerContext) importAnnotations(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != "POST" {
+		http.Error(w, "Not supported", 405)
+		return
+	}
+
+	defer req.Body.Close()
+	dec := json.NewDecoder(req.Body)
+	count := 0
+	for {
+		var a Annotation
+		err := dec.Decode(&a)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("import err after %d annotations: %s", count, err)
+			writeJSON(w, 400, map[string]interface{}{"result": "invalid_json", "imported": count})
+			return
+		}
+
+		a.ID = ""
+		if result, err := checkTimes(&a); err != nil {
+			log.Printf("import err after %d annotations: %s", count, err)
+			writeJSON(w, 400, map[string]interface{}{"result": result, "error": err.Error(), "imported": count})
+			return
+		}
+		if _, err := s.add(a); err != nil {
+			log.Printf("import err after %d annotations: %s", count, err)
+			writeJSON(w, 500, map[string]interface{}{"result": fmt.Sprintf("err: %s", err), "imported": count})
+			return
+		}
+		count++
+	}
+
+	writeJSON(w, 200, map[string]interface{}{"result": "ok", "imported": count})
+}
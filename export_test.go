@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *TestSetup) exportNDJSON(query string) (list []Annotation) {
+	res, err := http.Get(s.Server.URL + "/annotations/export?" + query)
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		s.T.Errorf("wrong content type: %s", ct)
+	}
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		var a Annotation
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			s.T.Errorf("err: %s line: %s", err, scanner.Text())
+			return
+		}
+		list = append(list, a)
+	}
+	return
+}
+
+func (s *TestSetup) importNDJSON(body string, expectedStatus int) (imported int) {
+	res, err := http.Post(s.Server.URL+"/annotations/import", "application/x-ndjson", strings.NewReader(body))
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Imported int `json:"imported"`
+	}
+	json.NewDecoder(res.Body).Decode(&result)
+	if res.StatusCode != expectedStatus {
+		s.T.Errorf("Expected code of %d, not: %d", expectedStatus, res.StatusCode)
+	}
+	return result.Imported
+}
+
+func (s *TestSetup) testExportImport() {
+	ts := int(time.Now().Unix())
+	body := fmt.Sprintf(`{"created_at": %d, "message": "imported 1", "tags": ["export1", "export2"]}
+{"created_at": %d, "message": "imported 2", "tags": ["export2"]}
+
+{"created_at": %d, "message": "imported 3", "tags": ["export3"]}
+`, ts-100, ts-50, ts)
+
+	ch := s.Ctx.broker.Subscribe()
+	defer s.Ctx.broker.Unsubscribe(ch)
+	if n := s.importNDJSON(body, 200); n != 3 {
+		s.T.Errorf("wrong number of imported annotations: %d", n)
+	}
+	// imported annotations are published to streams like added ones
+	select {
+	case a := <-ch:
+		if a.Message != "imported 1" || a.CreatedAt != (ts-100)*1000 {
+			s.T.Errorf("wrong published annotation: %#v", a)
+		}
+	case <-time.After(time.Second):
+		s.T.Errorf("imported annotation not published")
+	}
+	if n := s.importNDJSON(`{"message": "ok", "tags": ["export4"]}`+"\n{ BROKEN_JSON }\n", 400); n != 1 {
+		s.T.Errorf("wrong number of imported annotations: %d", n)
+	}
+
+	list := s.exportNDJSON("tags[]=export2")
+	if len(list) != 2 || list[0].Message != "imported 1" || list[0].CreatedAt != ts-100 || len(list[0].Tags) != 2 {
+		s.T.Errorf("wrong export: %#v", list)
+	}
+
+	list = s.exportNDJSON(fmt.Sprintf("tags[]=export2&tags[]=export3&from=%d&until=%d", ts-60, ts))
+	if len(list) != 2 || list[0].Message != "imported 2" || list[1].Message != "imported 3" {
+		s.T.Errorf("wrong export: %#v", list)
+	}
+
+	if list := s.exportNDJSON(""); len(list) < 4 {
+		s.T.Errorf("wrong export: %#v", list)
+	}
+
+	// annotations overlapping the range are exported, times can be given like for queries
+	if err := s.putJSON(fmt.Sprintf(`{"created_at": %d, "ends_at": %d, "message": "window", "tags": ["export6"]}`, ts-7200, ts-60), 200); err != nil {
+		s.T.Error(err)
+	}
+	from := time.Unix(int64(ts-120), 0).UTC().Format(time.RFC3339)
+	list = s.exportNDJSON(fmt.Sprintf("tags[]=export6&from=%s&until=%d", from, ts*1000))
+	if len(list) != 1 || list[0].CreatedAt != ts-7200 || list[0].EndsAt != ts-60 {
+		s.T.Errorf("wrong export: %#v", list)
+	}
+	for _, q := range []string{"from=yesterday", "until=x", fmt.Sprintf("from=%d&until=%d", ts, ts-60)} {
+		res, err := http.Get(s.Server.URL + "/annotations/export?" + q)
+		if err != nil {
+			s.T.Errorf("err: %s", err)
+			continue
+		}
+		res.Body.Close()
+		if res.StatusCode != 400 {
+			s.T.Errorf("%s: Expected code of 400, not: %d", q, res.StatusCode)
+		}
+	}
+
+	// times are checked like for PUT requests
+	body = fmt.Sprintf(`{"created_at": %d, "ends_at": %d, "message": "millis", "tags": ["export5"]}
+{"created_at": %d, "ends_at": %d, "message": "backwards", "tags": ["export5"]}
+{"created_at": %d, "message": "not imported", "tags": ["export5"]}
+`, ts*1000, (ts+60)*1000, ts, ts-60, ts)
+	if n := s.importNDJSON(body, 400); n != 1 {
+		s.T.Errorf("wrong number of imported annotations: %d", n)
+	}
+	list = s.exportNDJSON("tags[]=export5")
+	if len(list) != 1 || list[0].CreatedAt != ts || list[0].EndsAt != ts+60 {
+		s.T.Errorf("wrong export: %#v", list)
+	}
+}
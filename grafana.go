@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	Grafana SimpleJSON / JSON datasource support, use http://localhost:9119/grafana as the datasource URL.
+	the annotation query holds the tags to show, separated by commas or spaces, e.g. "build, deploy-*"
+*/
+
+type grafanaAnnotationQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Annotation grafanaAnnotationDef `json:"annotation"`
+}
+
+type grafanaAnnotationDef struct {
+	Name       string          `json:"name"`
+	Datasource json.RawMessage `json:"datasource,omitempty"`
+	IconColor  string          `json:"iconColor,omitempty"`
+	Enable     bool            `json:"enable"`
+	Query      string          `json:"query"`
+	Tags       json.RawMessage `json:"tags,omitempty"`
+}
+
+type grafanaAnnotation struct {
+	Annotation grafanaAnnotationDef `json:"annotation"`
+	Time       int64                `json:"time"`
+	TimeEnd    int64                `json:"timeEnd,omitempty"`
+	IsRegion   bool                 `json:"isRegion,omitempty"`
+	Title      string               `json:"title"`
+	Text       string               `json:"text"`
+	Tags       []string             `json:"tags"`
+}
+
+// tags returns the tags from the query string and the optional tags field which is either a list or a string
+func (d grafanaAnnotationDef) tags() []string {
+	split := func(s string) []string {
+		return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+
+	res := split(d.Query)
+	var list []string
+	var str string
+	if json.Unmarshal(d.Tags, &list) == nil {
+		res = append(res, list...)
+	} else if json.Unmarshal(d.Tags, &str) == nil {
+		res = append(res, split(str)...)
+	}
+	return res
+}
+
+func (s *ServerContext) grafana(w http.ResponseWriter, req *http.Request) {
+
+	// needed for datasources in direct (browser) access mode
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "accept, content-type")
+	if req.Method == "OPTIONS" {
+		return
+	}
+
+	switch strings.TrimPrefix(req.URL.Path, *grafanaEndpoint) {
+	case "", "/":
+		// used by grafana to test the datasource
+		writeJSON(w, 200, map[string]string{"result": "ok"})
+	case "/search":
+		tags := s.storage.AllTags()
+		sort.Strings(tags)
+		writeJSON(w, 200, tags)
+	case "/annotations":
+		s.grafanaAnnotations(w, req)
+	default:
+		http.Error(w, "Not found", 404)
+	}
+}
+
+func (s *ServerContext) grafanaAnnotations(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != "POST" {
+		http.Error(w, "Not supported", 405)
+		return
+	}
+
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	var q grafanaAnnotationQuery
+	if err := json.Unmarshal(body, &q); err != nil || q.Range.To.Before(q.Range.From) {
+		log.Printf("unmarshal grafana query error or bad range: %s", body)
+		writeJSON(w, 400, map[string]string{"result": "invalid_json"})
+		return
+	}
+
+	tags, err := ExpandTags(s.storage, q.Annotation.tags(), nil, *tagPatternLimit)
+	if _, ok := err.(ErrTooManyTags); ok {
+		writeJSON(w, 400, map[string]string{"result": "too_many_tags", "error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+		return
+	}
+	until := int(q.Range.To.Unix())
+	list, err := GetPosts(s.storage, tags, until-int(q.Range.From.Unix()), until)
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+		return
+	}
+
+	// an annotation is returned once for every matching tag, grafana should only show it once
+	res := make([]grafanaAnnotation, 0, len(list.Posts))
+	seen := make(map[string]int)
+	for _, a := range list.Posts {
+		if i, ok := seen[a.ID]; ok {
+			res[i].Tags = append(res[i].Tags, a.Tags...)
+			continue
+		}
+		seen[a.ID] = len(res)
+		res = append(res, grafanaAnnotation{
+			Annotation: q.Annotation,
+			Time:       int64(a.CreatedAt),
+			TimeEnd:    int64(a.EndsAt),
+			IsRegion:   a.EndsAt > a.CreatedAt,
+			Title:      strings.SplitN(a.Message, "\n", 2)[0],
+			Text:       a.Message,
+			Tags:       a.Tags,
+		})
+	}
+
+	writeJSON(w, 200, res)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	emulation of Grafana's HTTP annotations API so tools that post annotations to Grafana can use this server instead:
+		curl -XPOST -H "Content-Type: application/json" -d '{"time": 1430797123000, "tags": ["deploy"], "text": "deployed web server"}'  "localhost:9119/api/annotations"
+	times are in milliseconds like in Grafana, set timeEnd for region annotations. dashboardId and panelId are accepted but not stored.
+*/
+
+type grafanaAPIAnnotation struct {
+	ID          interface{} `json:"id,omitempty"`
+	DashboardID int64       `json:"dashboardId"`
+	PanelID     int64       `json:"panelId"`
+	Time        int64       `json:"time"`
+	TimeEnd     int64       `json:"timeEnd"`
+	Text        string      `json:"text"`
+	Tags        []string    `json:"tags"`
+}
+
+// grafanaID returns numeric IDs as numbers like grafana does, everything else as string
+func grafanaID(id string) interface{} {
+	if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+		return n
+	}
+	return id
+}
+
+// toGrafanaAPIAnnotation converts a query result, its times are already in milliseconds
+func toGrafanaAPIAnnotation(a Annotation) grafanaAPIAnnotation {
+	res := grafanaAPIAnnotation{
+		ID:      grafanaID(a.ID),
+		Time:    int64(a.CreatedAt),
+		TimeEnd: int64(a.End()),
+		Text:    a.Message,
+		Tags:    a.Tags,
+	}
+	if res.Tags == nil {
+		res.Tags = []string{}
+	}
+	return res
+}
+
+func (s *ServerContext) grafanaAPI(w http.ResponseWriter, req *http.Request) {
+
+	id := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, *grafanaAPIEndpoint), "/")
+
+	switch {
+	case id == "" && req.Method == "GET":
+		s.grafanaAPIList(w, req)
+	case id == "" && req.Method == "POST":
+		s.grafanaAPIAdd(w, req)
+	case id != "" && (req.Method == "PUT" || req.Method == "PATCH"):
+		s.grafanaAPIUpdate(w, req, id)
+	case id != "" && req.Method == "DELETE":
+		s.grafanaAPIDelete(w, req, id)
+	default:
+		writeJSON(w, 405, map[string]string{"message": "Method not allowed"})
+	}
+}
+
+func (s *ServerContext) grafanaAPIList(w http.ResponseWriter, req *http.Request) {
+
+	req.ParseForm()
+	now := time.Now().Unix() * 1000
+	from, _ := strconv.ParseInt(req.Form.Get("from"), 10, 64)
+	to, err := strconv.ParseInt(req.Form.Get("to"), 10, 64)
+	if err != nil {
+		to = now
+	}
+	limit, err := strconv.Atoi(req.Form.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 100
+	}
+	tags := req.Form["tags"]
+	matchAny := req.Form.Get("matchAny") == "true"
+
+	// storages work in seconds, an annotation overlaps [from, to] in milliseconds if it overlaps
+	// [from rounded up, to rounded down] in seconds
+	until := int(to / 1000)
+	r := until - int((from+999)/1000)
+	list := make([]Annotation, 0)
+	if r >= 0 && len(tags) > 0 {
+		op := TagAnd
+		if matchAny {
+			op = TagOr
+		}
+		var posts Posts
+		posts, err = GetPostsForTags(s.storage, tags, op, r, until)
+		list = posts.Posts
+	} else if r >= 0 {
+		err = s.storage.List(r, until, ListOpts{Desc: true, Limit: limit}, &list)
+	}
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"message": fmt.Sprintf("err: %s", err)})
+		return
+	}
+
+	// grafana returns the newest annotations first
+	list, _ = Paginate(list, ListOpts{Desc: true, Limit: limit})
+	res := make([]grafanaAPIAnnotation, 0, len(list))
+	for _, a := range list {
+		res = append(res, toGrafanaAPIAnnotation(a))
+	}
+	writeJSON(w, 200, res)
+}
+
+func (s *ServerContext) readGrafanaAPIAnnotation(w http.ResponseWriter, req *http.Request) (ga grafanaAPIAnnotation, fields map[string]json.RawMessage, ok bool) {
+
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	if json.Unmarshal(body, &ga) != nil || json.Unmarshal(body, &fields) != nil {
+		log.Printf("unmarshal grafana annotation error: %s", body)
+		writeJSON(w, 400, map[string]string{"message": "bad request data"})
+		return ga, nil, false
+	}
+	return ga, fields, true
+}
+
+func (s *ServerContext) grafanaAPIAdd(w http.ResponseWriter, req *http.Request) {
+
+	ga, _, ok := s.readGrafanaAPIAnnotation(w, req)
+	if !ok {
+		return
+	}
+
+	a := Annotation{CreatedAt: int(ga.Time / 1000), Message: ga.Text, Tags: ga.Tags}
+	if a.CreatedAt == 0 {
+		a.CreatedAt = int(time.Now().Unix())
+	}
+	if ga.TimeEnd > ga.Time {
+		a.EndsAt = int(ga.TimeEnd / 1000)
+	}
+	id, err := s.add(a)
+	if err != nil {
+		log.Printf("add grafana annotation err: %s", err)
+		writeJSON(w, 500, map[string]string{"message": "Failed to save annotation"})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"message": "Annotation added", "id": grafanaID(id)})
+}
+
+// grafanaAPIUpdate handles both PUT and PATCH, PUT requests have to send all fields anyway
+func (s *ServerContext) grafanaAPIUpdate(w http.ResponseWriter, req *http.Request, id string) {
+
+	ga, fields, ok := s.readGrafanaAPIAnnotation(w, req)
+	if !ok {
+		return
+	}
+
+	var p AnnotationPatch
+	if _, ok := fields["time"]; ok {
+		createdAt := int(ga.Time / 1000)
+		p.CreatedAt = &createdAt
+	}
+	if _, ok := fields["timeEnd"]; ok {
+		endsAt := int(ga.TimeEnd / 1000)
+		p.EndsAt = &endsAt
+	}
+	if _, ok := fields["text"]; ok {
+		p.Message = &ga.Text
+	}
+	if _, ok := fields["tags"]; ok {
+		p.Tags = &ga.Tags
+	}
+
+	_, err := s.storage.Update(id, p)
+	switch err {
+	case nil:
+		writeJSON(w, 200, map[string]string{"message": "Annotation updated"})
+	case ErrNotFound:
+		writeJSON(w, 404, map[string]string{"message": "Annotation not found"})
+	default:
+		log.Printf("update grafana annotation %s err: %s", id, err)
+		writeJSON(w, 500, map[string]string{"message": "Failed to update annotation"})
+	}
+}
+
+func (s *ServerContext) grafanaAPIDelete(w http.ResponseWriter, req *http.Request, id string) {
+
+	err := s.storage.Delete(id)
+	switch err {
+	case nil:
+		writeJSON(w, 200, map[string]string{"message": "Annotation deleted"})
+	case ErrNotFound:
+		writeJSON(w, 404, map[string]string{"message": "Annotation not found"})
+	default:
+		log.Printf("delete grafana annotation %s err: %s", id, err)
+		writeJSON(w, 500, map[string]string{"message": "Failed to delete annotation"})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *TestSetup) grafanaAPIRequest(method, path, body string, expectedStatus int, res interface{}) {
+	request, _ := http.NewRequest(method, s.Server.URL+*grafanaAPIEndpoint+path, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		s.T.Errorf("%s %s: Expected code of %d, not: %d", method, path, expectedStatus, resp.StatusCode)
+		return
+	}
+	if res != nil {
+		if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+			s.T.Errorf("err: %s", err)
+		}
+	}
+}
+
+func (s *TestSetup) testGrafanaAPI() {
+	ms := time.Now().Unix() * 1000
+
+	var added struct {
+		Message string      `json:"message"`
+		ID      interface{} `json:"id"`
+	}
+	s.grafanaAPIRequest("POST", "", fmt.Sprintf(`{"dashboardId": 1, "panelId": 2, "time": %d, "tags": ["gapi1", "gapi2"], "text": "deployed"}`, ms-60000), 200, &added)
+	if added.Message != "Annotation added" || added.ID == nil {
+		s.T.Errorf("wrong response: %#v", added)
+		return
+	}
+	id := fmt.Sprintf("%v", added.ID)
+	s.grafanaAPIRequest("POST", "", fmt.Sprintf(`{"time": %d, "tags": ["gapi2"], "text": "older"}`, ms-120000), 200, nil)
+	s.grafanaAPIRequest("POST", "", `{ BROKEN_JSON }`, 400, nil)
+
+	var list []grafanaAPIAnnotation
+	s.grafanaAPIRequest("GET", fmt.Sprintf("?from=%d&to=%d&tags=gapi2", ms-3600000, ms), "", 200, &list)
+	if len(list) != 2 || list[0].Text != "deployed" || list[0].Time != ms-60000 || list[1].Text != "older" {
+		s.T.Errorf("wrong annotations: %#v", list)
+	}
+
+	s.grafanaAPIRequest("GET", fmt.Sprintf("?from=%d&to=%d&tags=gapi1&tags=gapi2", ms-3600000, ms), "", 200, &list)
+	if len(list) != 1 {
+		s.T.Errorf("wrong annotations: %#v", list)
+	}
+
+	s.grafanaAPIRequest("GET", fmt.Sprintf("?from=%d&to=%d&tags=gapi1&tags=gapi2&matchAny=true&limit=1", ms-3600000, ms), "", 200, &list)
+	if len(list) != 1 || list[0].Text != "deployed" {
+		s.T.Errorf("wrong annotations: %#v", list)
+	}
+
+	// without tags all annotations are returned, including untagged ones
+	s.grafanaAPIRequest("POST", "", fmt.Sprintf(`{"time": %d, "text": "untagged"}`, ms-3333000), 200, nil)
+	s.grafanaAPIRequest("GET", fmt.Sprintf("?from=%d&to=%d", ms-3334000, ms-3332000), "", 200, &list)
+	if len(list) == 0 || list[0].Text != "untagged" || list[0].Time != ms-3333000 {
+		s.T.Errorf("wrong annotations: %#v", list)
+	}
+
+	s.grafanaAPIRequest("PATCH", "/"+id, `{"text": "deployed v2"}`, 200, nil)
+	s.grafanaAPIRequest("PATCH", "/does-not-exist", `{"text": "deployed v2"}`, 404, nil)
+	s.grafanaAPIRequest("GET", fmt.Sprintf("?from=%d&to=%d&tags=gapi1", ms-3600000, ms), "", 200, &list)
+	if len(list) != 1 || list[0].Text != "deployed v2" || len(list[0].Tags) != 2 {
+		s.T.Errorf("wrong annotations: %#v", list)
+	}
+
+	s.grafanaAPIRequest("DELETE", "/"+id, "", 200, nil)
+	s.grafanaAPIRequest("DELETE", "/"+id, "", 404, nil)
+	s.grafanaAPIRequest("GET", fmt.Sprintf("?from=%d&to=%d&tags=gapi1", ms-3600000, ms), "", 200, &list)
+	if len(list) != 0 {
+		s.T.Errorf("wrong annotations: %#v", list)
+	}
+}
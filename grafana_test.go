@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *TestSetup) grafanaQuery(body string, expectedStatus int) (list []grafanaAnnotation) {
+	res, err := http.Post(s.Server.URL+*grafanaEndpoint+"/annotations", "application/json", strings.NewReader(body))
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != expectedStatus {
+		s.T.Errorf("Expected code of %d, not: %d", expectedStatus, res.StatusCode)
+		return
+	}
+	if expectedStatus == 200 {
+		if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+			s.T.Errorf("err: %s", err)
+		}
+	}
+	return
+}
+
+func (s *TestSetup) testGrafana() {
+	now := time.Now()
+	ts := int(now.Unix())
+	s.putJSON(fmt.Sprintf(`{"created_at": %d, "message": "deploy\nall the things", "tags": ["grafana1", "grafana2"]}`, ts-60), 200)
+	s.put("msg2", "grafana2", ts-7200)
+	s.put("msg3", "grafana3", ts-30)
+
+	res, err := http.Get(s.Server.URL + *grafanaEndpoint + "/")
+	if err != nil || res.StatusCode != 200 {
+		s.T.Errorf("err: %s or datasource test failed", err)
+	} else {
+		res.Body.Close()
+	}
+
+	from := now.Add(-time.Hour).UTC().Format(time.RFC3339)
+	to := now.UTC().Format(time.RFC3339)
+	query := `{"range": {"from": "%s", "to": "%s"}, "annotation": {"name": "deploys", "enable": true, "query": "%s"%s}}`
+
+	list := s.grafanaQuery(fmt.Sprintf(query, from, to, "grafana1, grafana2", ""), 200)
+	if len(list) != 1 || list[0].Time != int64(ts-60)*1000 || list[0].Title != "deploy" || list[0].Text != "deploy\nall the things" || len(list[0].Tags) != 2 || list[0].Annotation.Name != "deploys" {
+		s.T.Errorf("wrong annotations: %#v", list)
+	}
+
+	list = s.grafanaQuery(fmt.Sprintf(query, from, to, "grafana1", `, "tags": ["grafana3"]`), 200)
+	if len(list) != 2 {
+		s.T.Errorf("wrong annotations: %#v", list)
+	}
+
+	s.grafanaQuery(`{ BROKEN_JSON }`, 400)
+	s.grafanaQuery(fmt.Sprintf(query, to, from, "grafana1", ""), 400)
+}
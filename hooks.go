@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+/*
+	webhook receivers that turn events from other systems into annotations:
+		/hooks/alertmanager    Alertmanager webhook notifications
+		/hooks/github          GitHub push, release and deployment events
+		/hooks/gitlab          GitLab push, merge request, release and deployment events
+		/hooks/custom/<name>   routes declared in --hooks-config
+*/
+
+func (s *ServerContext) hooks(w http.ResponseWriter, req *http.Request) {
+
+	if req.Method != "POST" {
+		http.Error(w, "Not supported", 405)
+		return
+	}
+
+	path := strings.TrimPrefix(req.URL.Path, *hooksEndpoint)
+	switch {
+	case path == "/alertmanager":
+		s.alertmanagerHook(w, req)
+	case path == "/github":
+		s.githubHook(w, req)
+	case path == "/gitlab":
+		s.gitlabHook(w, req)
+	case strings.HasPrefix(path, "/custom/"):
+		s.customHook(w, req, strings.TrimPrefix(path, "/custom/"))
+	default:
+		http.Error(w, "Not found", 404)
+	}
+}
+
+// splitList splits a comma separated flag value and drops empty entries
+func splitList(s string) (res []string) {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+	point an Alertmanager webhook receiver at http://localhost:9119/hooks/alertmanager
+	every alert that starts firing or gets resolved becomes an annotation, tagged with the
+	values of the labels set by --alertmanager-tag-labels, or "alertmanager" if it has none of them.
+	repeated notifications are skipped for 24h by seenAlerts, which lives in memory only, so after a restart
+	alertmanager's next notification of a still firing alert annotates it again.
+*/
+
+var alertmanagerTagLabels = flag.String("alertmanager-tag-labels", "alertname,severity", "Comma separated alert labels whose values are used as tags for Alertmanager annotations")
+
+type alertmanagerPayload struct {
+	Version  string              `json:"version"`
+	Status   string              `json:"status"`
+	Receiver string              `json:"receiver"`
+	Alerts   []alertmanagerAlert `json:"alerts"`
+}
+
+type alertmanagerAlert struct {
+	Status       string            `json:"status"`
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL"`
+	Fingerprint  string            `json:"fingerprint"`
+}
+
+// key identifies a state transition of an alert, alertmanager keeps sending firing alerts on every group update
+func (a alertmanagerAlert) key() string {
+	id := a.Fingerprint
+	if id == "" {
+		pairs := make([]string, 0, len(a.Labels))
+		for k, v := range a.Labels {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		id = strings.Join(pairs, ",")
+	}
+	return fmt.Sprintf("%s/%s/%d", a.Status, id, a.StartsAt.Unix())
+}
+
+func (a alertmanagerAlert) annotation(tagLabels []string) Annotation {
+	res := Annotation{
+		CreatedAt: int(a.StartsAt.Unix()),
+		Source:    "alertmanager",
+		URL:       a.GeneratorURL,
+		Severity:  a.Labels["severity"],
+		Labels:    copyLabels(a.Labels),
+	}
+	switch {
+	case a.Status == "resolved" && !a.EndsAt.IsZero():
+		res.CreatedAt = int(a.EndsAt.Unix())
+	case a.StartsAt.IsZero():
+		res.CreatedAt = int(time.Now().Unix())
+	}
+
+	res.Message = fmt.Sprintf("[%s] %s", strings.ToUpper(a.Status), a.Labels["alertname"])
+	if summary := a.Annotations["summary"]; summary != "" {
+		res.Message += ": " + summary
+	}
+
+	for _, l := range tagLabels {
+		if v := a.Labels[l]; v != "" {
+			res.Tags = append(res.Tags, v)
+		}
+	}
+	if len(res.Tags) == 0 {
+		res.Tags = []string{"alertmanager"}
+	}
+	return res
+}
+
+// seenAlerts remembers recently annotated alert transitions
+type seenAlerts struct {
+	sync.Mutex
+	keys map[string]time.Time
+}
+
+func newSeenAlerts() *seenAlerts {
+	return &seenAlerts{keys: make(map[string]time.Time)}
+}
+
+// add returns false if key was added already within maxAge
+func (s *seenAlerts) add(key string, now time.Time, maxAge time.Duration) bool {
+	s.Lock()
+	defer s.Unlock()
+
+	for k, t := range s.keys {
+		if now.Sub(t) > maxAge {
+			delete(s.keys, k)
+		}
+	}
+	if _, ok := s.keys[key]; ok {
+		return false
+	}
+	s.keys[key] = now
+	return true
+}
+
+// remove forgets key so the alert is accepted again
+func (s *seenAlerts) remove(key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.keys, key)
+}
+
+func (s *ServerContext) alertmanagerHook(w http.ResponseWriter, req *http.Request) {
+
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	var p alertmanagerPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("unmarshal alertmanager payload error: %s", body)
+		writeJSON(w, 400, map[string]string{"result": "invalid_json"})
+		return
+	}
+
+	tagLabels := splitList(*alertmanagerTagLabels)
+	now := time.Now()
+	count := 0
+	for _, alert := range p.Alerts {
+		if !s.seenAlerts.add(alert.key(), now, 24*time.Hour) {
+			continue
+		}
+		if _, err := s.add(alert.annotation(tagLabels)); err != nil {
+			// alertmanager retries failed notifications, those must not be skipped as duplicates
+			s.seenAlerts.remove(alert.key())
+			log.Printf("add alertmanager annotation err: %s", err)
+			writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+			return
+		}
+		count++
+	}
+
+	writeJSON(w, 200, map[string]interface{}{"result": "ok", "added": count})
+}
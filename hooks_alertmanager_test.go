@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (s *TestSetup) postHook(path, body string, headers map[string]string, expectedStatus int) (added int) {
+	request, _ := http.NewRequest("POST", s.Server.URL+*hooksEndpoint+path, strings.NewReader(body))
+	for k, v := range headers {
+		request.Header.Set(k, v)
+	}
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Added int `json:"added"`
+	}
+	json.NewDecoder(res.Body).Decode(&result)
+	if res.StatusCode != expectedStatus {
+		s.T.Errorf("POST %s: Expected code of %d, not: %d", path, expectedStatus, res.StatusCode)
+	}
+	return result.Added
+}
+
+func (s *TestSetup) testAlertmanagerHook() {
+	now := time.Now().UTC()
+	startsAt := now.Add(-10 * time.Minute).Format(time.RFC3339)
+	endsAt := now.Add(-time.Minute).Format(time.RFC3339)
+
+	payload := `{"version": "4", "status": "%s", "receiver": "annotations", "alerts": [
+		{"status": "firing", "labels": {"alertname": "AMHighLatency", "severity": "amcritical"}, "annotations": {"summary": "latency is high"}, "startsAt": "%s", "endsAt": "0001-01-01T00:00:00Z"},
+		{"status": "%s", "labels": {"alertname": "AMDiskFull", "instance": "db1"}, "startsAt": "%s", "endsAt": "%s"}
+	]}`
+
+	if n := s.postHook("/alertmanager", fmt.Sprintf(payload, "firing", startsAt, "firing", startsAt, "0001-01-01T00:00:00Z"), nil, 200); n != 2 {
+		s.T.Errorf("wrong number of added annotations: %d", n)
+	}
+	// repeated notifications for the same alerts must not add them again
+	if n := s.postHook("/alertmanager", fmt.Sprintf(payload, "firing", startsAt, "firing", startsAt, "0001-01-01T00:00:00Z"), nil, 200); n != 0 {
+		s.T.Errorf("wrong number of added annotations: %d", n)
+	}
+	if n := s.postHook("/alertmanager", fmt.Sprintf(payload, "firing", startsAt, "resolved", startsAt, endsAt), nil, 200); n != 1 {
+		s.T.Errorf("wrong number of added annotations: %d", n)
+	}
+	s.postHook("/alertmanager", `{ BROKEN_JSON }`, nil, 400)
+	s.postHook("/does-not-exist", `{}`, nil, 404)
+
+	l, err := s.query("amcritical", int(now.Unix()))
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "[FIRING] AMHighLatency: latency is high" ||
+		l.Posts[0].Source != "alertmanager" || l.Posts[0].Severity != "amcritical" || l.Posts[0].Labels["alertname"] != "AMHighLatency" {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+
+	l, err = s.query("AMDiskFull", int(now.Unix()))
+	if err != nil || len(l.Posts) != 2 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+		return
+	}
+	resolved := l.Posts[0]
+	if resolved.CreatedAt < l.Posts[1].CreatedAt {
+		resolved = l.Posts[1]
+	}
+	if resolved.Message != "[RESOLVED] AMDiskFull" || resolved.CreatedAt != int(now.Add(-time.Minute).Unix())*1000 {
+		s.T.Errorf("Wrong resolved annotation: %#v", resolved)
+	}
+}
+
+func TestAlertmanagerAnnotationTimes(t *testing.T) {
+	endsAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	// resolved alerts are annotated when they ended, even without a start time
+	a := alertmanagerAlert{Status: "resolved", EndsAt: endsAt}.annotation(nil)
+	if a.CreatedAt != int(endsAt.Unix()) {
+		t.Errorf("no good, wrong created_at %d for resolved alert", a.CreatedAt)
+	}
+	before := int(time.Now().Unix())
+	a = alertmanagerAlert{Status: "firing"}.annotation(nil)
+	if a.CreatedAt < before {
+		t.Errorf("no good, firing alert without start should be annotated now, got %d", a.CreatedAt)
+	}
+}
+
+// failingStorage fails to add annotations while fail is set
+type failingStorage struct {
+	Storage
+	fail bool
+}
+
+func (s *failingStorage) Add(a Annotation) (string, error) {
+	if s.fail {
+		return "", errors.New("storage down")
+	}
+	return s.Storage.Add(a)
+}
+
+func TestAlertmanagerHookRetry(t *testing.T) {
+	st := &failingStorage{Storage: NewMemoryStorage(), fail: true}
+	defer st.Cleanup()
+	ctx := &ServerContext{storage: st, seenAlerts: newSeenAlerts(), broker: newBroker()}
+
+	now := time.Now().UTC()
+	payload := fmt.Sprintf(`{"version": "4", "status": "firing", "alerts": [
+		{"status": "firing", "labels": {"alertname": "RetryAlert"}, "startsAt": "%s", "endsAt": "0001-01-01T00:00:00Z"}
+	]}`, now.Add(-time.Minute).Format(time.RFC3339))
+	post := func() int {
+		w := httptest.NewRecorder()
+		ctx.alertmanagerHook(w, httptest.NewRequest("POST", "/hooks/alertmanager", strings.NewReader(payload)))
+		return w.Code
+	}
+
+	if code := post(); code != 500 {
+		t.Errorf("no good, expected 500, got %d", code)
+	}
+	st.fail = false
+	if code := post(); code != 200 {
+		t.Errorf("no good, expected 200, got %d", code)
+	}
+	var list []Annotation
+	if err := st.ListForTag("RetryAlert", 3600, int(now.Unix()), ListOpts{}, &list); err != nil || len(list) != 1 {
+		t.Errorf("no good, the retry should store the annotation, err: %s list: %#v", err, list)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/*
+	custom webhooks for tools without a dedicated receiver, declared in the JSON file set by --hooks-config:
+		{
+			"jenkins": {
+				"message": "{{.name}} build {{.build.number}}: {{.build.status}}",
+				"time": "$.build.timestamp",
+				"tags": ["jenkins", "{{.name}}", "$.build.parameters.environment"],
+				"url": "$.build.full_url"
+			}
+		}
+	every route is served at /hooks/custom/<name>. expressions starting with $ are JSONPath like
+	($.a.b[0], $.list[*], $['some-key']), everything else is a Go text/template executed on the payload.
+	a JSONPath tag that matches a list adds every element as a tag, empty tags are skipped and
+	annotations without any tags are tagged with the name of the route.
+	the time is optional and can be in seconds, milliseconds or RFC3339, it defaults to now.
+	author, url and severity are optional as well, the source of the annotations is the name of the route.
+*/
+
+var (
+	hooksConfig = flag.String("hooks-config", "", "JSON file declaring custom webhook routes, see hooks_custom.go")
+)
+
+type customHookConfig struct {
+	Message  string   `json:"message"`
+	Time     string   `json:"time"`
+	Tags     []string `json:"tags"`
+	Author   string   `json:"author"`
+	URL      string   `json:"url"`
+	Severity string   `json:"severity"`
+}
+
+// hookExpr is either a JSONPath or a text/template
+type hookExpr struct {
+	path []hookPathStep
+	tmpl *template.Template
+}
+
+type hookPathStep struct {
+	key   string
+	index int
+	all   bool
+}
+
+type customHook struct {
+	message  *hookExpr
+	time     *hookExpr
+	tags     []*hookExpr
+	author   *hookExpr
+	url      *hookExpr
+	severity *hookExpr
+}
+
+func LoadCustomHooks(fName string) (map[string]*customHook, error) {
+	data, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCustomHooks(data)
+}
+
+func ParseCustomHooks(data []byte) (map[string]*customHook, error) {
+	var config map[string]customHookConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]*customHook)
+	for name, c := range config {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("invalid hook name: %q", name)
+		}
+		if c.Message == "" {
+			return nil, fmt.Errorf("hook %s: message is required", name)
+		}
+
+		h := &customHook{}
+		var err error
+		if h.message, err = parseHookExpr(c.Message); err != nil {
+			return nil, fmt.Errorf("hook %s: message: %s", name, err)
+		}
+		if c.Time != "" {
+			if h.time, err = parseHookExpr(c.Time); err != nil {
+				return nil, fmt.Errorf("hook %s: time: %s", name, err)
+			}
+		}
+		for _, f := range []struct {
+			name, expr string
+			res        **hookExpr
+		}{{"author", c.Author, &h.author}, {"url", c.URL, &h.url}, {"severity", c.Severity, &h.severity}} {
+			if f.expr == "" {
+				continue
+			}
+			if *f.res, err = parseHookExpr(f.expr); err != nil {
+				return nil, fmt.Errorf("hook %s: %s: %s", name, f.name, err)
+			}
+		}
+		for _, t := range c.Tags {
+			e, err := parseHookExpr(t)
+			if err != nil {
+				return nil, fmt.Errorf("hook %s: tag %q: %s", name, t, err)
+			}
+			h.tags = append(h.tags, e)
+		}
+		res[name] = h
+	}
+	return res, nil
+}
+
+func parseHookExpr(s string) (*hookExpr, error) {
+	if !strings.HasPrefix(s, "$") {
+		t, err := template.New("").Option("missingkey=error").Parse(s)
+		return &hookExpr{tmpl: t}, err
+	}
+
+	e := &hookExpr{}
+	rest := s[1:]
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return nil, fmt.Errorf("empty key in path %s", s)
+			}
+			e.path = append(e.path, hookPathStep{key: rest[1:end]})
+			rest = rest[end:]
+		case rest[0] == '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("missing ] in path %s", s)
+			}
+			sel := rest[1:end]
+			switch {
+			case sel == "*":
+				e.path = append(e.path, hookPathStep{all: true})
+			case len(sel) >= 2 && sel[0] == '\'' && sel[len(sel)-1] == '\'':
+				e.path = append(e.path, hookPathStep{key: sel[1 : len(sel)-1]})
+			default:
+				i, err := strconv.Atoi(sel)
+				if err != nil {
+					return nil, fmt.Errorf("invalid index %s in path %s", sel, s)
+				}
+				e.path = append(e.path, hookPathStep{index: i})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in path %s", rest[0], s)
+		}
+	}
+	return e, nil
+}
+
+// eval returns the values the expression matches in the payload, a template always returns one value
+func (e *hookExpr) eval(payload interface{}) ([]string, error) {
+	if e.tmpl != nil {
+		var buf bytes.Buffer
+		if err := e.tmpl.Execute(&buf, payload); err != nil {
+			return nil, err
+		}
+		return []string{buf.String()}, nil
+	}
+
+	values := []interface{}{payload}
+	for _, step := range e.path {
+		var next []interface{}
+		for _, v := range values {
+			switch v := v.(type) {
+			case map[string]interface{}:
+				if step.all {
+					for _, item := range v {
+						next = append(next, item)
+					}
+				} else if item, ok := v[step.key]; ok && step.key != "" {
+					next = append(next, item)
+				}
+			case []interface{}:
+				if step.all {
+					next = append(next, v...)
+				} else if step.key == "" && step.index >= 0 && step.index < len(v) {
+					next = append(next, v[step.index])
+				}
+			}
+		}
+		values = next
+	}
+
+	var res []string
+	for _, v := range values {
+		// a path ending at a list matches all of its elements
+		if list, ok := v.([]interface{}); ok {
+			for _, item := range list {
+				res = append(res, hookString(item))
+			}
+			continue
+		}
+		res = append(res, hookString(v))
+	}
+	return res, nil
+}
+
+func hookString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+// parseHookTime accepts seconds or milliseconds since the epoch and RFC3339 timestamps
+func parseHookTime(s string) (int, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return EpochSeconds(int(f))
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	return int(t.Unix()), nil
+}
+
+func (h *customHook) annotation(payload interface{}) (a Annotation, err error) {
+	msg, err := h.message.eval(payload)
+	if err != nil {
+		return a, err
+	}
+	a.Message = strings.Join(msg, ", ")
+
+	for _, f := range []struct {
+		e   *hookExpr
+		res *string
+	}{{h.author, &a.Author}, {h.url, &a.URL}, {h.severity, &a.Severity}} {
+		if f.e == nil {
+			continue
+		}
+		v, err := f.e.eval(payload)
+		if err != nil {
+			return a, err
+		}
+		*f.res = strings.Join(v, ", ")
+	}
+
+	a.CreatedAt = int(time.Now().Unix())
+	if h.time != nil {
+		ts, err := h.time.eval(payload)
+		if err != nil {
+			return a, err
+		}
+		if len(ts) > 0 && ts[0] != "" {
+			if a.CreatedAt, err = parseHookTime(ts[0]); err != nil {
+				return a, err
+			}
+		}
+	}
+
+	for _, e := range h.tags {
+		tags, err := e.eval(payload)
+		if err != nil {
+			return a, err
+		}
+		for _, t := range tags {
+			if t = strings.TrimSpace(t); t != "" {
+				a.Tags = append(a.Tags, t)
+			}
+		}
+	}
+	return a, nil
+}
+
+func (s *ServerContext) customHook(w http.ResponseWriter, req *http.Request, name string) {
+
+	h, ok := s.customHooks[name]
+	if !ok {
+		http.Error(w, "Not found", 404)
+		return
+	}
+
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	dec := json.NewDecoder(bytes.NewReader(body))
+	// keeps large numbers like timestamps exact when they end up in a template
+	dec.UseNumber()
+	var payload interface{}
+	if err := dec.Decode(&payload); err != nil {
+		log.Printf("unmarshal %s payload error: %s", name, body)
+		writeJSON(w, 400, map[string]string{"result": "invalid_json"})
+		return
+	}
+
+	a, err := h.annotation(payload)
+	if err != nil {
+		log.Printf("hook %s: %s", name, err)
+		writeJSON(w, 400, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+		return
+	}
+	if len(a.Tags) == 0 {
+		a.Tags = []string{name}
+	}
+	a.Source = name
+	id, err := s.add(a)
+	if err != nil {
+		log.Printf("add %s annotation err: %s", name, err)
+		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"result": "ok", "added": 1, "id": id})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCustomHooks(t *testing.T) {
+	for _, config := range []string{
+		`{ BROKEN_JSON }`,
+		`{"nomessage": {"tags": ["a"]}}`,
+		`{"a/b": {"message": "hi"}}`,
+		`{"badtemplate": {"message": "{{.name"}}`,
+		`{"badpath": {"message": "hi", "tags": ["$.list[x]"]}}`,
+		`{"badpath": {"message": "hi", "tags": ["$..a"]}}`,
+		`{"badpath": {"message": "hi", "time": "$.list[0"}}`,
+	} {
+		if _, err := ParseCustomHooks([]byte(config)); err == nil {
+			t.Errorf("no good, expected error for config: %s", config)
+		}
+	}
+}
+
+func TestHookExpr(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(`{"name": "web", "build": {"number": 12, "ok": true}, "envs": ["prod", "staging"], "some-key": "v", "stages": [{"name": "test"}, {"name": "deploy"}]}`))
+	dec.UseNumber()
+	var payload interface{}
+	dec.Decode(&payload)
+
+	for expr, expected := range map[string][]string{
+		"{{.name}}-{{.build.number}}": {"web-12"},
+		"$.name":                      {"web"},
+		"$.build.number":              {"12"},
+		"$.build.ok":                  {"true"},
+		"$.envs":                      {"prod", "staging"},
+		"$.envs[1]":                   {"staging"},
+		"$.envs[5]":                   nil,
+		"$['some-key']":               {"v"},
+		"$.stages[*].name":            {"test", "deploy"},
+		"$.missing.key":               nil,
+	} {
+		e, err := parseHookExpr(expr)
+		if err != nil {
+			t.Errorf("no good, parsing %s failed: %s", expr, err)
+			continue
+		}
+		res, err := e.eval(payload)
+		if err != nil || !reflect.DeepEqual(res, expected) {
+			t.Errorf("no good, %s returned %v, err: %s", expr, res, err)
+		}
+	}
+
+	e, _ := parseHookExpr("{{.missing}}")
+	if _, err := e.eval(payload); err == nil {
+		t.Errorf("no good, expected error for missing key")
+	}
+}
+
+func TestParseHookTime(t *testing.T) {
+	for s, expected := range map[string]int{
+		"1430797123":           1430797123,
+		"1430797123000":        1430797123,
+		"1430797123.5":         1430797123,
+		"2015-05-05T03:38:43Z": 1430797123,
+	} {
+		if ts, err := parseHookTime(s); err != nil || ts != expected {
+			t.Errorf("no good, %s parsed as %d, err: %s", s, ts, err)
+		}
+	}
+	if _, err := parseHookTime("yesterday"); err == nil {
+		t.Errorf("no good, expected error")
+	}
+}
+
+func (s *TestSetup) testCustomHooks() {
+	hooks, err := ParseCustomHooks([]byte(`{
+		"jenkins": {
+			"message": "{{.name}} build {{.build.number}}: {{.build.status}}",
+			"time": "$.build.timestamp",
+			"tags": ["customjenkins", "$.build.parameters.environment"],
+			"url": "$.build.full_url"
+		},
+		"untagged": {"message": "{{.text}}"}
+	}`))
+	if err != nil {
+		s.T.Fatalf("err: %s", err)
+	}
+	s.Ctx.customHooks = hooks
+	defer func() { s.Ctx.customHooks = nil }()
+
+	now := time.Now().Unix()
+	payload := fmt.Sprintf(`{"name": "web", "build": {"number": 42, "status": "SUCCESS", "timestamp": %d, "full_url": "http://jenkins/job/web/42/", "parameters": {"environment": ["customprod", "customstaging"]}}}`, now*1000)
+	if n := s.postHook("/custom/jenkins", payload, nil, 200); n != 1 {
+		s.T.Errorf("wrong number of added annotations: %d", n)
+	}
+	s.postHook("/custom/jenkins", `{"name": "web"}`, nil, 400)
+	s.postHook("/custom/jenkins", `{ BROKEN_JSON }`, nil, 400)
+	s.postHook("/custom/does-not-exist", `{}`, nil, 404)
+	s.postHook("/custom/untagged", `{"text": "something happened"}`, nil, 200)
+
+	for _, tag := range []string{"customjenkins", "customprod", "customstaging"} {
+		l, err := s.query(tag, int(now))
+		if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "web build 42: SUCCESS" || l.Posts[0].CreatedAt != int(now)*1000 ||
+			l.Posts[0].Source != "jenkins" || l.Posts[0].URL != "http://jenkins/job/web/42/" {
+			s.T.Errorf("err: %s or Wrong l.Posts for %s: %#v", err, tag, l.Posts)
+		}
+	}
+	// the route stamps the annotation when the request arrives, a second may have passed since now
+	if l, err := s.query("untagged", int(time.Now().Unix())); err != nil || len(l.Posts) != 1 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+	GitHub: add a webhook with content type application/json, the secret set by --github-secret
+	and the URL http://localhost:9119/hooks/github, push, pull request, release and deployment status events are annotated.
+	deployments are annotated once their status is success, with the event "deployment" like on GitLab,
+	pull requests once they are merged, with the event "merge_request" and their base branch.
+	GitLab: add a webhook with the secret token set by --gitlab-secret and the URL
+	http://localhost:9119/hooks/gitlab, push, merge request, release and deployment events are annotated.
+
+	the tags of the annotations are built from --vcs-tags, a comma separated list of templates with the
+	placeholders {provider}, {event}, {owner}, {repo} and {branch}. a tag is skipped if one of its placeholders is empty.
+*/
+
+var (
+	githubSecret = flag.String("github-secret", "", "Secret to verify the signature of GitHub webhooks, required to accept them")
+	gitlabSecret = flag.String("gitlab-secret", "", "Secret token to verify GitLab webhooks, required to accept them")
+	vcsTags      = flag.String("vcs-tags", "{repo},{repo}-{branch}", "Comma separated tag templates for GitHub and GitLab annotations")
+)
+
+// vcsEvent is the common part of GitHub and GitLab events we need for an annotation
+type vcsEvent struct {
+	Provider string
+	Event    string
+	Owner    string
+	Repo     string
+	Branch   string
+	Message  string
+	Author   string
+	URL      string
+}
+
+func (e vcsEvent) tags(templates []string) (res []string) {
+	values := map[string]string{
+		"{provider}": e.Provider,
+		"{event}":    e.Event,
+		"{owner}":    e.Owner,
+		"{repo}":     e.Repo,
+		"{branch}":   e.Branch,
+	}
+	pairs := make([]string, 0, 2*len(values))
+	for k, v := range values {
+		pairs = append(pairs, k, v)
+	}
+	r := strings.NewReplacer(pairs...)
+
+Templates:
+	for _, t := range templates {
+		for k, v := range values {
+			if v == "" && strings.Contains(t, k) {
+				continue Templates
+			}
+		}
+		res = append(res, r.Replace(t))
+	}
+	return res
+}
+
+func (e vcsEvent) annotation(templates []string) Annotation {
+	tags := e.tags(templates)
+	if len(tags) == 0 {
+		tags = []string{e.Provider}
+	}
+	return Annotation{
+		CreatedAt: int(time.Now().Unix()),
+		Message:   e.Message,
+		Tags:      tags,
+		Author:    e.Author,
+		Source:    e.Provider,
+		URL:       e.URL,
+	}
+}
+
+func firstLine(s string) string {
+	return strings.SplitN(s, "\n", 2)[0]
+}
+
+// branchName strips refs/heads/ and refs/tags/ from git refs
+func branchName(ref string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(ref, "refs/heads/"), "refs/tags/")
+}
+
+type githubPayload struct {
+	Ref        string `json:"ref"`
+	Deleted    bool   `json:"deleted"`
+	Action     string `json:"action"`
+	Compare    string `json:"compare"`
+	Repository struct {
+		Name  string `json:"name"`
+		Owner struct {
+			Login string `json:"login"`
+		} `json:"owner"`
+	} `json:"repository"`
+	Pusher struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+	HeadCommit struct {
+		Message string `json:"message"`
+	} `json:"head_commit"`
+	Release struct {
+		TagName string `json:"tag_name"`
+		Name    string `json:"name"`
+		HTMLURL string `json:"html_url"`
+	} `json:"release"`
+	Deployment struct {
+		Ref         string `json:"ref"`
+		Environment string `json:"environment"`
+		Description string `json:"description"`
+	} `json:"deployment"`
+	DeploymentStatus struct {
+		State     string `json:"state"`
+		TargetURL string `json:"target_url"`
+	} `json:"deployment_status"`
+	PullRequest struct {
+		Number  int    `json:"number"`
+		Title   string `json:"title"`
+		HTMLURL string `json:"html_url"`
+		Merged  bool   `json:"merged"`
+		Base    struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+}
+
+// githubEvent returns false for events that shouldn't be annotated
+func githubEvent(event string, p githubPayload) (vcsEvent, bool) {
+	e := vcsEvent{Provider: "github", Event: event, Owner: p.Repository.Owner.Login, Repo: p.Repository.Name, Author: p.Sender.Login}
+
+	switch {
+	case event == "push" && !p.Deleted:
+		e.Branch = branchName(p.Ref)
+		e.Author = p.Pusher.Name
+		e.URL = p.Compare
+		e.Message = fmt.Sprintf("push to %s/%s by %s: %s", e.Repo, e.Branch, p.Pusher.Name, firstLine(p.HeadCommit.Message))
+	case event == "pull_request" && p.Action == "closed" && p.PullRequest.Merged:
+		e.Event = "merge_request"
+		e.Branch = p.PullRequest.Base.Ref
+		e.URL = p.PullRequest.HTMLURL
+		e.Message = fmt.Sprintf("pull request #%d merged into %s/%s by %s: %s", p.PullRequest.Number, e.Repo, e.Branch, p.Sender.Login, p.PullRequest.Title)
+	case event == "release" && p.Action == "published":
+		e.Branch = p.Release.TagName
+		e.URL = p.Release.HTMLURL
+		e.Message = fmt.Sprintf("release %s of %s published: %s", p.Release.TagName, e.Repo, p.Release.Name)
+	case event == "deployment_status" && p.DeploymentStatus.State == "success":
+		e.Event = "deployment"
+		e.Branch = branchName(p.Deployment.Ref)
+		e.URL = p.DeploymentStatus.TargetURL
+		e.Message = fmt.Sprintf("deployment of %s %s to %s: %s", e.Repo, e.Branch, p.Deployment.Environment, p.Deployment.Description)
+	default:
+		return e, false
+	}
+	return e, true
+}
+
+type gitlabPayload struct {
+	ObjectKind        string `json:"object_kind"`
+	Ref               string `json:"ref"`
+	UserName          string `json:"user_name"`
+	TotalCommitsCount int    `json:"total_commits_count"`
+	Project           struct {
+		PathWithNamespace string `json:"path_with_namespace"`
+	} `json:"project"`
+	User struct {
+		Name string `json:"name"`
+	} `json:"user"`
+	ObjectAttributes struct {
+		IID          int    `json:"iid"`
+		Title        string `json:"title"`
+		URL          string `json:"url"`
+		Action       string `json:"action"`
+		TargetBranch string `json:"target_branch"`
+	} `json:"object_attributes"`
+	// release events
+	Action string `json:"action"`
+	Tag    string `json:"tag"`
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	// deployment events
+	Status      string `json:"status"`
+	Environment string `json:"environment"`
+}
+
+// gitlabEvent returns false for events that shouldn't be annotated
+func gitlabEvent(p gitlabPayload) (vcsEvent, bool) {
+	e := vcsEvent{Provider: "gitlab", Event: p.ObjectKind, Author: p.User.Name}
+	path := p.Project.PathWithNamespace
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		e.Owner, e.Repo = path[:i], path[i+1:]
+	} else {
+		e.Repo = path
+	}
+
+	switch {
+	case p.ObjectKind == "push" && p.TotalCommitsCount > 0:
+		e.Branch = branchName(p.Ref)
+		e.Author = p.UserName
+		e.Message = fmt.Sprintf("push of %d commits to %s/%s by %s", p.TotalCommitsCount, e.Repo, e.Branch, p.UserName)
+	case p.ObjectKind == "merge_request" && p.ObjectAttributes.Action == "merge":
+		e.Branch = p.ObjectAttributes.TargetBranch
+		e.URL = p.ObjectAttributes.URL
+		e.Message = fmt.Sprintf("merge request !%d merged into %s/%s by %s: %s", p.ObjectAttributes.IID, e.Repo, e.Branch, p.User.Name, p.ObjectAttributes.Title)
+	case p.ObjectKind == "release" && p.Action == "create":
+		e.Branch = p.Tag
+		e.URL = p.URL
+		e.Message = fmt.Sprintf("release %s of %s published: %s", p.Tag, e.Repo, p.Name)
+	case p.ObjectKind == "deployment" && p.Status == "success":
+		e.Branch = p.Ref
+		e.Message = fmt.Sprintf("deployment of %s %s to %s", e.Repo, e.Branch, p.Environment)
+	default:
+		return e, false
+	}
+	return e, true
+}
+
+// validGitHubSignature checks the X-Hub-Signature-256 header, "sha256=" followed by the hex encoded HMAC of the body
+func validGitHubSignature(secret string, body []byte, signature string) bool {
+	if secret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+func validGitLabToken(secret, token string) bool {
+	return secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(token)) == 1
+}
+
+func (s *ServerContext) addVCSEvent(w http.ResponseWriter, e vcsEvent, ok bool) {
+
+	if !ok {
+		writeJSON(w, 200, map[string]interface{}{"result": "ok", "added": 0})
+		return
+	}
+	if _, err := s.add(e.annotation(splitList(*vcsTags))); err != nil {
+		log.Printf("add %s annotation err: %s", e.Provider, err)
+		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+		return
+	}
+	writeJSON(w, 200, map[string]interface{}{"result": "ok", "added": 1})
+}
+
+func (s *ServerContext) githubHook(w http.ResponseWriter, req *http.Request) {
+
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	if !validGitHubSignature(*githubSecret, body, req.Header.Get("X-Hub-Signature-256")) {
+		writeJSON(w, 403, map[string]string{"result": "invalid_signature"})
+		return
+	}
+
+	var p githubPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("unmarshal github payload error: %s", body)
+		writeJSON(w, 400, map[string]string{"result": "invalid_json"})
+		return
+	}
+	e, ok := githubEvent(req.Header.Get("X-GitHub-Event"), p)
+	s.addVCSEvent(w, e, ok)
+}
+
+func (s *ServerContext) gitlabHook(w http.ResponseWriter, req *http.Request) {
+
+	defer req.Body.Close()
+	if !validGitLabToken(*gitlabSecret, req.Header.Get("X-Gitlab-Token")) {
+		writeJSON(w, 403, map[string]string{"result": "invalid_token"})
+		return
+	}
+
+	body, _ := ioutil.ReadAll(req.Body)
+	var p gitlabPayload
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("unmarshal gitlab payload error: %s", body)
+		writeJSON(w, 400, map[string]string{"result": "invalid_json"})
+		return
+	}
+	e, ok := gitlabEvent(p)
+	s.addVCSEvent(w, e, ok)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func githubSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVCSEventTags(t *testing.T) {
+	e := vcsEvent{Provider: "github", Event: "push", Owner: "acme", Repo: "web", Branch: "main"}
+	tags := e.tags([]string{"{repo}", "{owner}-{repo}-{branch}", "{provider}:{event}"})
+	if !reflect.DeepEqual(tags, []string{"web", "acme-web-main", "github:push"}) {
+		t.Errorf("no good, wrong tags: %v", tags)
+	}
+
+	e.Branch = ""
+	tags = e.tags([]string{"{repo}", "{repo}-{branch}"})
+	if !reflect.DeepEqual(tags, []string{"web"}) {
+		t.Errorf("no good, templates with empty placeholders should be skipped: %v", tags)
+	}
+}
+
+func (s *TestSetup) testVCSHooks() {
+	defer func(tags string) { *githubSecret, *gitlabSecret, *vcsTags = "", "", tags }(*vcsTags)
+	*githubSecret = "gh-secret"
+	*gitlabSecret = "gl-secret"
+	*vcsTags = "{repo}-{branch},vcs-{provider}"
+
+	push := `{"ref": "refs/heads/main", "repository": {"name": "vcsweb", "owner": {"login": "acme"}}, "pusher": {"name": "jane"}, "head_commit": {"message": "fix login\n\nlonger description"}}`
+	if n := s.postHook("/github", push, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": githubSignature("gh-secret", push)}, 200); n != 1 {
+		s.T.Errorf("wrong number of added annotations: %d", n)
+	}
+	s.postHook("/github", push, map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": githubSignature("wrong", push)}, 403)
+	s.postHook("/github", push, map[string]string{"X-GitHub-Event": "push"}, 403)
+
+	release := `{"action": "published", "release": {"tag_name": "v1.2.0", "name": "Spring release"}, "repository": {"name": "vcsweb", "owner": {"login": "acme"}}}`
+	if n := s.postHook("/github", release, map[string]string{"X-GitHub-Event": "release", "X-Hub-Signature-256": githubSignature("gh-secret", release)}, 200); n != 1 {
+		s.T.Errorf("wrong number of added annotations: %d", n)
+	}
+	// deployments are annotated once they succeeded
+	for _, tc := range []struct {
+		event, body string
+		expected    int
+	}{
+		{"deployment", `{"deployment": {"ref": "refs/heads/release", "environment": "prod"}, "repository": {"name": "vcsweb", "owner": {"login": "acme"}}}`, 0},
+		{"deployment_status", `{"deployment_status": {"state": "pending"}, "deployment": {"ref": "refs/heads/release", "environment": "prod"}, "repository": {"name": "vcsweb", "owner": {"login": "acme"}}}`, 0},
+		{"deployment_status", `{"deployment_status": {"state": "success"}, "deployment": {"ref": "refs/heads/release", "environment": "prod", "description": "v1.2.0"}, "repository": {"name": "vcsweb", "owner": {"login": "acme"}}}`, 1},
+	} {
+		if n := s.postHook("/github", tc.body, map[string]string{"X-GitHub-Event": tc.event, "X-Hub-Signature-256": githubSignature("gh-secret", tc.body)}, 200); n != tc.expected {
+			s.T.Errorf("wrong number of added annotations for %s: %d", tc.event, n)
+		}
+	}
+	// pull requests are annotated once they are merged, for their base branch
+	for _, tc := range []struct {
+		body     string
+		expected int
+	}{
+		{`{"action": "opened", "pull_request": {"number": 12, "title": "Add search", "base": {"ref": "develop"}}, "sender": {"login": "jane"}, "repository": {"name": "vcsweb", "owner": {"login": "acme"}}}`, 0},
+		{`{"action": "closed", "pull_request": {"number": 11, "title": "Abandoned", "merged": false, "base": {"ref": "develop"}}, "sender": {"login": "jane"}, "repository": {"name": "vcsweb", "owner": {"login": "acme"}}}`, 0},
+		{`{"action": "closed", "pull_request": {"number": 12, "title": "Add search", "merged": true, "base": {"ref": "develop"}}, "sender": {"login": "jane"}, "repository": {"name": "vcsweb", "owner": {"login": "acme"}}}`, 1},
+	} {
+		if n := s.postHook("/github", tc.body, map[string]string{"X-GitHub-Event": "pull_request", "X-Hub-Signature-256": githubSignature("gh-secret", tc.body)}, 200); n != tc.expected {
+			s.T.Errorf("wrong number of added annotations for %s: %d", tc.body, n)
+		}
+	}
+	// events we don't annotate are accepted and ignored
+	ping := `{"zen": "Keep it logically awesome."}`
+	if n := s.postHook("/github", ping, map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": githubSignature("gh-secret", ping)}, 200); n != 0 {
+		s.T.Errorf("wrong number of added annotations: %d", n)
+	}
+
+	mr := `{"object_kind": "merge_request", "user": {"name": "joe"}, "project": {"path_with_namespace": "acme/backend/vcsapi"}, "object_attributes": {"iid": 7, "title": "Add caching", "action": "merge", "target_branch": "main"}}`
+	if n := s.postHook("/gitlab", mr, map[string]string{"X-Gitlab-Token": "gl-secret"}, 200); n != 1 {
+		s.T.Errorf("wrong number of added annotations: %d", n)
+	}
+	s.postHook("/gitlab", mr, map[string]string{"X-Gitlab-Token": "wrong"}, 403)
+	s.postHook("/gitlab", `{ BROKEN_JSON }`, map[string]string{"X-Gitlab-Token": "gl-secret"}, 400)
+
+	now := int(time.Now().Unix())
+	l, err := s.query("vcsweb-main", now)
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "push to vcsweb/main by jane: fix login" {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	l, err = s.query("vcsweb-v1.2.0", now)
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "release v1.2.0 of vcsweb published: Spring release" {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	l, err = s.query("vcsapi-main", now)
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "merge request !7 merged into vcsapi/main by joe: Add caching" {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	l, err = s.query("vcsweb-release", now)
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "deployment of vcsweb release to prod: v1.2.0" {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	l, err = s.query("vcsweb-develop", now)
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "pull request #12 merged into vcsweb/develop by jane: Add search" {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	if l, err = s.query("vcs-github", now); err != nil || len(l.Posts) != 4 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+}
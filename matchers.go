@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+	PromQL-style label matchers to select annotations by their labels:
+		curl -G --data-urlencode 'match[]={service="api",env=~"prod|staging"}' "localhost:9119/annotations"
+	like in Prometheus, regular expressions are fully anchored, a missing label has the empty value
+	and every selector needs at least one matcher that doesn't match the empty string.
+*/
+
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+var matchOps = []struct {
+	op string
+	t  MatchType
+}{
+	// longer operators first so "=~" isn't read as "="
+	{"=~", MatchRegexp},
+	{"!~", MatchNotRegexp},
+	{"!=", MatchNotEqual},
+	{"=", MatchEqual},
+}
+
+func (t MatchType) String() string {
+	for _, o := range matchOps {
+		if o.t == t {
+			return o.op
+		}
+	}
+	return "?"
+}
+
+type LabelMatcher struct {
+	Name  string
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+func NewLabelMatcher(t MatchType, name, value string) (*LabelMatcher, error) {
+	m := &LabelMatcher{Name: name, Type: t, Value: value}
+	if t == MatchRegexp || t == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+func (m *LabelMatcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+// Matches reports whether the label value v matches, missing labels have to be passed as ""
+func (m *LabelMatcher) Matches(v string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	case MatchNotRegexp:
+		return !m.re.MatchString(v)
+	}
+	return false
+}
+
+// MatchesLabels reports whether the annotation's labels match all of ms
+func (a Annotation) MatchesLabels(ms []*LabelMatcher) bool {
+	for _, m := range ms {
+		if !m.Matches(a.Labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseMatchers parses a selector like {service="api",env=~"prod|staging"}, the braces are optional.
+// values are double quoted with Go escapes or in backticks.
+func ParseMatchers(s string) (res []*LabelMatcher, err error) {
+	in := strings.TrimSpace(s)
+	if strings.HasPrefix(in, "{") {
+		if !strings.HasSuffix(in, "}") {
+			return nil, fmt.Errorf("missing } in selector %s", s)
+		}
+		in = in[1 : len(in)-1]
+	}
+
+	for {
+		in = strings.TrimSpace(in)
+		if in == "" {
+			break
+		}
+
+		i := 0
+		for i < len(in) && (in[i] == '_' || in[i] >= 'a' && in[i] <= 'z' || in[i] >= 'A' && in[i] <= 'Z' || i > 0 && in[i] >= '0' && in[i] <= '9') {
+			i++
+		}
+		if i == 0 {
+			return nil, fmt.Errorf("expected label name at %q", in)
+		}
+		name := in[:i]
+		in = strings.TrimSpace(in[i:])
+
+		var t MatchType
+		found := false
+		for _, o := range matchOps {
+			if strings.HasPrefix(in, o.op) {
+				t, found = o.t, true
+				in = strings.TrimSpace(in[len(o.op):])
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("expected =, !=, =~ or !~ after %s", name)
+		}
+
+		value, rest, err := unquoteValue(in)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %s", name, err)
+		}
+		m, err := NewLabelMatcher(t, name, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp for %s: %s", name, err)
+		}
+		res = append(res, m)
+
+		in = strings.TrimSpace(rest)
+		if in != "" {
+			if in[0] != ',' {
+				return nil, fmt.Errorf("expected , at %q", in)
+			}
+			in = in[1:]
+		}
+	}
+
+	for _, m := range res {
+		if !m.Matches("") {
+			return res, nil
+		}
+	}
+	return nil, fmt.Errorf("selector %s needs at least one matcher that doesn't match empty labels", s)
+}
+
+// unquoteValue returns the quoted string at the start of s and whatever follows it
+func unquoteValue(s string) (value, rest string, err error) {
+	if s == "" || (s[0] != '"' && s[0] != '`') {
+		return "", "", fmt.Errorf("expected quoted string at %q", s)
+	}
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		if s[i] == '\\' && q == '"' {
+			i++
+			continue
+		}
+		if s[i] == q {
+			value, err = strconv.Unquote(s[:i+1])
+			return value, s[i+1:], err
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string %s", s)
+}
+
+// GetPostsForMatchers returns the annotations matching any of the selectors, each of them once
+func GetPostsForMatchers(s Storage, selectors [][]*LabelMatcher, r, until int) (res []Annotation, err error) {
+	seen := make(map[string]bool)
+	for _, ms := range selectors {
+		var list []Annotation
+		if err := s.ListForMatchers(ms, r, until, &list); err != nil {
+			return res, err
+		}
+		for _, a := range list {
+			if !seen[a.ID] {
+				seen[a.ID] = true
+				res = append(res, a)
+			}
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseMatchers(t *testing.T) {
+	ms, err := ParseMatchers(`{service="api", env=~"prod|staging",team!="ops",region!~` + "`eu-.*`" + `}`)
+	if err != nil || len(ms) != 4 {
+		t.Errorf("no good, err: %s matchers: %v", err, ms)
+		return
+	}
+	for i, expected := range []string{`service="api"`, `env=~"prod|staging"`, `team!="ops"`, `region!~"eu-.*"`} {
+		if ms[i].String() != expected {
+			t.Errorf("no good, expected %s, got %s", expected, ms[i])
+		}
+	}
+
+	if ms, err := ParseMatchers(`service="a\"b"`); err != nil || len(ms) != 1 || ms[0].Value != `a"b` {
+		t.Errorf("no good, err: %s matchers: %v", err, ms)
+	}
+
+	for _, sel := range []string{
+		``,
+		`{}`,
+		`{service="api"`,
+		`{service}`,
+		`{service=api}`,
+		`{service="api" env="prod"}`,
+		`{service=~"("}`,
+		`{service="unterminated}`,
+		`{1service="api"}`,
+		// every matcher matches the empty string
+		`{service!="api"}`,
+		`{env=~".*"}`,
+	} {
+		if _, err := ParseMatchers(sel); err == nil {
+			t.Errorf("no good, expected error for %s", sel)
+		}
+	}
+}
+
+func TestMatchesLabels(t *testing.T) {
+	a := Annotation{Labels: map[string]string{"service": "api", "env": "prod"}}
+	for sel, expected := range map[string]bool{
+		`{service="api"}`:                     true,
+		`{service="api",env=~"prod|staging"}`: true,
+		`{service="api",env=~"prod.+"}`:       false,
+		`{service="api",env!="prod"}`:         false,
+		`{service="api",team=""}`:             true,
+		`{service="api",team!=""}`:            false,
+		`{service=~"a.*",env!~"dev|test"}`:    true,
+		`{service="web"}`:                     false,
+	} {
+		ms, err := ParseMatchers(sel)
+		if err != nil {
+			t.Errorf("no good, err: %s", err)
+			continue
+		}
+		if a.MatchesLabels(ms) != expected {
+			t.Errorf("no good, %s should return %t", sel, expected)
+		}
+	}
+}
+
+func (s *TestSetup) testMatchers() {
+	ts := int(time.Now().Unix())
+	for _, body := range []string{
+		`{"created_at": %d, "message": "api prod", "tags": ["matchtag1"], "labels": {"service": "matchapi", "env": "prod"}}`,
+		`{"created_at": %d, "message": "api staging", "tags": ["matchtag1", "matchtag2"], "labels": {"service": "matchapi", "env": "staging"}}`,
+		`{"created_at": %d, "message": "api dev", "tags": ["matchtag2"], "labels": {"service": "matchapi", "env": "dev"}}`,
+		`{"created_at": %d, "message": "web prod", "tags": ["matchtag2"], "labels": {"service": "matchweb", "env": "prod"}}`,
+	} {
+		if err := s.putJSON(fmt.Sprintf(body, ts), 200); err != nil {
+			s.T.Error(err)
+		}
+	}
+
+	l, err := s.queryURL(fmt.Sprintf("%s/annotations?until=%d&range=60&match[]=%s", s.Server.URL, ts, url.QueryEscape(`{service="matchapi",env=~"prod|staging"}`)))
+	if err != nil || len(l.Posts) != 2 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	for _, a := range l.Posts {
+		if a.Message == "api staging" && len(a.Tags) != 2 {
+			s.T.Errorf("matched annotations should have all tags: %#v", a)
+		}
+	}
+
+	// several selectors return every annotation once
+	l, err = s.queryURL(fmt.Sprintf("%s/annotations?until=%d&range=60&match[]=%s&match[]=%s", s.Server.URL, ts,
+		url.QueryEscape(`{env="prod",service=~"match.+"}`), url.QueryEscape(`{service="matchweb"}`)))
+	if err != nil || len(l.Posts) != 2 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+
+	res, err := http.Get(fmt.Sprintf("%s/annotations?match[]=%s", s.Server.URL, url.QueryEscape(`{env=~"("}`)))
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != 400 {
+		s.T.Errorf("Expected code of 400, not: %d", res.StatusCode)
+	}
+}
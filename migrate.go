@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+/*
+	to copy all annotations from one storage to another:
+		./prom_annotation_server --storage=local:/tmp/annotations.db --migrate-to=rethinkdb:localhost:28015/annotations
+	timestamps, messages and tags are kept, IDs are assigned by the destination.
+*/
+
+type TagVerification struct {
+	Tag      string
+	Source   int
+	Migrated int
+}
+
+func (v TagVerification) OK() bool {
+	return v.Source == v.Migrated
+}
+
+type MigrationResult struct {
+	Annotations int
+	Tags        []TagVerification
+}
+
+func (r MigrationResult) OK() bool {
+	for _, v := range r.Tags {
+		if !v.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// Migrate copies every annotation from src to dst and compares the per-tag counts afterwards.
+// dst may already contain annotations, only the number of added annotations is compared.
+func Migrate(src, dst Storage, progressEvery int) (res MigrationResult, err error) {
+	pre, err := dst.TagStats()
+	if err != nil {
+		return res, fmt.Errorf("getting tag stats of destination: %s", err)
+	}
+
+	err = src.Walk(func(a Annotation) error {
+		a.ID = ""
+		if _, err := dst.Add(a); err != nil {
+			return fmt.Errorf("adding annotation created at %d: %s", a.CreatedAt, err)
+		}
+		res.Annotations++
+		if progressEvery > 0 && res.Annotations%progressEvery == 0 {
+			log.Printf("Migrated %d annotations", res.Annotations)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+	log.Printf("Migrated %d annotations", res.Annotations)
+
+	srcStats, err := src.TagStats()
+	if err != nil {
+		return res, fmt.Errorf("getting tag stats of source: %s", err)
+	}
+	post, err := dst.TagStats()
+	if err != nil {
+		return res, fmt.Errorf("getting tag stats of destination: %s", err)
+	}
+
+	for tag, count := range srcStats {
+		res.Tags = append(res.Tags, TagVerification{Tag: tag, Source: count, Migrated: post[tag] - pre[tag]})
+	}
+	sort.Slice(res.Tags, func(i, j int) bool { return res.Tags[i].Tag < res.Tags[j].Tag })
+	return res, nil
+}
+
+func runMigration(from, to string) error {
+	src, err := NewStorage(from)
+	if err != nil {
+		return fmt.Errorf("source storage config borked, err: %s", err)
+	}
+	defer src.Close()
+
+	dst, err := NewStorage(to)
+	if err != nil {
+		return fmt.Errorf("destination storage config borked, err: %s", err)
+	}
+	defer dst.Close()
+
+	res, err := Migrate(src, dst, 1000)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range res.Tags {
+		status := "ok"
+		if !v.OK() {
+			status = "MISMATCH"
+		}
+		fmt.Printf("%-40s source: %6d  migrated: %6d  %s\n", v.Tag, v.Source, v.Migrated, status)
+	}
+	if !res.OK() {
+		return fmt.Errorf("verification failed, tag counts differ")
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMigrate(t *testing.T) {
+	ts := int(time.Now().Unix())
+	src := NewMemoryStorage()
+	dst, err := NewBoltDBStorage(fmt.Sprintf("./test-%d.db", ts))
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer dst.Cleanup()
+
+	dst.Add(Annotation{CreatedAt: ts, Message: "Already there", Tags: []string{"tag1"}})
+
+	src.Add(Annotation{CreatedAt: ts - 10, Message: "Test message 1", Tags: []string{"tag1", "tag2"}})
+	src.Add(Annotation{CreatedAt: ts - 5, Message: "Test message 2", Tags: []string{"tag2"}})
+	src.Add(Annotation{CreatedAt: ts, Message: "Test message 3", Tags: []string{"tag3"}})
+
+	res, err := Migrate(src, dst, 1)
+	if err != nil || res.Annotations != 3 || len(res.Tags) != 3 || !res.OK() {
+		t.Errorf("no good, res: %#v err: %s", res, err)
+	}
+
+	if c := dst.GetCount("tag1"); c != 2 {
+		t.Errorf("no good, wrong count %d", c)
+	}
+
+	list, err := GetPosts(dst, []string{"tag2"}, 1000, ts)
+	if err != nil || len(list.Posts) != 2 || list.Posts[0].CreatedAt != (ts-10)*1000 || list.Posts[0].Message != "Test message 1" {
+		t.Errorf("no good, wrong list: %#v, err: %s", list, err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+	large queries can be paged, every full page comes with the cursor for the next one:
+		curl "localhost:9119/annotations?all=1&limit=100&order=desc"
+		curl "localhost:9119/annotations?all=1&limit=100&order=desc&cursor=<cursor>"
+	results are ordered by creation time and ID, order=desc returns the newest first.
+	limit counts annotations, one with several of the queried tags is still returned once per tag.
+*/
+
+// Cursor is the position of an annotation in paged results, CreatedAt is in seconds
+type Cursor struct {
+	CreatedAt int
+	ID        string
+}
+
+// Less reports whether c sorts before o, IDs are compared as strings
+func (c Cursor) Less(o Cursor) bool {
+	if c.CreatedAt != o.CreatedAt {
+		return c.CreatedAt < o.CreatedAt
+	}
+	return c.ID < o.ID
+}
+
+// position returns the cursor of a stored annotation
+func (a Annotation) position() Cursor {
+	return Cursor{CreatedAt: a.CreatedAt, ID: a.ID}
+}
+
+// ListOpts pages and orders ListForTag results, the zero value returns all of them oldest first
+type ListOpts struct {
+	Limit int     // at most this many annotations, 0 for all of them
+	Desc  bool    // newest first
+	After *Cursor // only annotations following this position in the requested order
+}
+
+// follows reports whether position p comes after o.After in the requested order
+func (o ListOpts) follows(p Cursor) bool {
+	if o.After == nil {
+		return true
+	}
+	if o.Desc {
+		return p.Less(*o.After)
+	}
+	return o.After.Less(p)
+}
+
+func EncodeCursor(c Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d:%s", c.CreatedAt, c.ID)))
+}
+
+func ParseCursor(s string) (c Cursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor %s", s)
+	}
+	parts := strings.SplitN(string(b), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return c, fmt.Errorf("invalid cursor %s", s)
+	}
+	if c.CreatedAt, err = strconv.Atoi(parts[0]); err != nil {
+		return c, fmt.Errorf("invalid cursor %s", s)
+	}
+	c.ID = parts[1]
+	return c, nil
+}
+
+// ParseListOpts reads limit, order and cursor from a query, paged is false if none of them is set
+func ParseListOpts(form url.Values) (opts ListOpts, paged bool, err error) {
+	if v := form.Get("limit"); v != "" {
+		if opts.Limit, err = strconv.Atoi(v); err != nil || opts.Limit < 1 {
+			return opts, false, fmt.Errorf("invalid limit %s", v)
+		}
+		paged = true
+	}
+	switch v := form.Get("order"); v {
+	case "":
+	case "asc":
+		paged = true
+	case "desc":
+		opts.Desc, paged = true, true
+	default:
+		return opts, false, fmt.Errorf("invalid order %s, use asc or desc", v)
+	}
+	if v := form.Get("cursor"); v != "" {
+		c, err := ParseCursor(v)
+		if err != nil {
+			return opts, false, err
+		}
+		opts.After, paged = &c, true
+	}
+	return opts, paged, nil
+}
+
+// Paginate orders query results and returns the page selected by opts.
+// next is set if there may be more results, list has to hold at least the first opts.Limit+1 of them.
+func Paginate(list []Annotation, opts ListOpts) (page []Annotation, next *Cursor) {
+	// results are in milliseconds
+	pos := func(a Annotation) Cursor { return Cursor{CreatedAt: a.CreatedAt / 1000, ID: a.ID} }
+
+	page = make([]Annotation, 0, len(list))
+	for _, a := range list {
+		if opts.follows(pos(a)) {
+			page = append(page, a)
+		}
+	}
+	sort.SliceStable(page, func(i, j int) bool {
+		if opts.Desc {
+			return pos(page[j]).Less(pos(page[i]))
+		}
+		return pos(page[i]).Less(pos(page[j]))
+	})
+	if opts.Limit == 0 {
+		return page, nil
+	}
+
+	// copies of the same annotation for different tags count once
+	count := 0
+	for i, a := range page {
+		if i == 0 || pos(a) != pos(page[i-1]) {
+			count++
+		}
+		if count > opts.Limit {
+			c := pos(page[i-1])
+			return page[:i], &c
+		}
+	}
+	return page, nil
+}
+
+// GetPage is GetPosts for paged queries, every tag is only read as far as needed
+func GetPage(s Storage, tags []string, ra, until int, opts ListOpts) (res Posts, err error) {
+	res.Posts = make([]Annotation, 0)
+	perTag := opts
+	if opts.Limit > 0 {
+		// one more to know whether there is another page
+		perTag.Limit = opts.Limit + 1
+	}
+	for _, tag := range tags {
+		if err := s.ListForTag(tag, ra, until, perTag, &res.Posts); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseCursor(t *testing.T) {
+	c := Cursor{CreatedAt: 1430797123, ID: "42:a"}
+	if parsed, err := ParseCursor(EncodeCursor(c)); err != nil || parsed != c {
+		t.Errorf("no good, err: %s cursor: %#v", err, parsed)
+	}
+	for _, s := range []string{"", "!!!", EncodeCursor(Cursor{}), "MTIz"} {
+		if _, err := ParseCursor(s); err == nil {
+			t.Errorf("no good, expected error for %s", s)
+		}
+	}
+}
+
+func TestParseListOpts(t *testing.T) {
+	opts, paged, err := ParseListOpts(url.Values{})
+	if err != nil || paged || opts.Limit != 0 || opts.Desc || opts.After != nil {
+		t.Errorf("no good, err: %s opts: %#v", err, opts)
+	}
+
+	c := Cursor{CreatedAt: 10, ID: "1"}
+	opts, paged, err = ParseListOpts(url.Values{"limit": {"5"}, "order": {"desc"}, "cursor": {EncodeCursor(c)}})
+	if err != nil || !paged || opts.Limit != 5 || !opts.Desc || opts.After == nil || *opts.After != c {
+		t.Errorf("no good, err: %s opts: %#v", err, opts)
+	}
+
+	for _, q := range []url.Values{{"limit": {"0"}}, {"limit": {"x"}}, {"order": {"up"}}, {"cursor": {"x"}}} {
+		if _, _, err := ParseListOpts(q); err == nil {
+			t.Errorf("no good, expected error for %v", q)
+		}
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	// "2" has two tags so it's there twice
+	list := []Annotation{
+		{ID: "3", CreatedAt: 30000, Tags: []string{"tag1"}},
+		{ID: "1", CreatedAt: 10000, Tags: []string{"tag1"}},
+		{ID: "2", CreatedAt: 20000, Tags: []string{"tag1"}},
+		{ID: "2", CreatedAt: 20000, Tags: []string{"tag2"}},
+		{ID: "4", CreatedAt: 30000, Tags: []string{"tag2"}},
+	}
+
+	page, next := Paginate(list, ListOpts{Limit: 2})
+	if len(page) != 3 || page[0].ID != "1" || page[2].ID != "2" || next == nil || *next != (Cursor{20, "2"}) {
+		t.Errorf("no good, page: %#v next: %#v", page, next)
+		return
+	}
+	page, next = Paginate(list, ListOpts{Limit: 2, After: next})
+	if len(page) != 2 || page[0].ID != "3" || page[1].ID != "4" || next != nil {
+		t.Errorf("no good, page: %#v next: %#v", page, next)
+	}
+
+	page, next = Paginate(list, ListOpts{Limit: 1, Desc: true})
+	if len(page) != 1 || page[0].ID != "4" || next == nil {
+		t.Errorf("no good, page: %#v next: %#v", page, next)
+	}
+}
+
+func (s *TestSetup) testPaging() {
+	ts := int(time.Now().Unix())
+	for i := 0; i < 5; i++ {
+		if err := s.putJSON(fmt.Sprintf(`{"created_at": %d, "message": "page %d", "tags": ["pagetag"]}`, ts-i, i), 200); err != nil {
+			s.T.Error(err)
+		}
+	}
+
+	var messages []string
+	cursor := ""
+	for i := 0; i < 5; i++ {
+		l, err := s.queryURL(fmt.Sprintf("%s/annotations?until=%d&range=60&tags[]=pagetag&limit=2&order=desc&cursor=%s", s.Server.URL, ts, cursor))
+		if err != nil || len(l.Posts) > 2 {
+			s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+			return
+		}
+		for _, a := range l.Posts {
+			messages = append(messages, a.Message)
+		}
+		if cursor = l.Cursor; cursor == "" {
+			break
+		}
+	}
+	if fmt.Sprint(messages) != "[page 0 page 1 page 2 page 3 page 4]" {
+		s.T.Errorf("Wrong pages: %v", messages)
+	}
+
+	res, err := http.Get(fmt.Sprintf("%s/annotations?tags[]=pagetag&limit=-1", s.Server.URL))
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != 400 {
+		s.T.Errorf("Expected code of 400, not: %d", res.StatusCode)
+	}
+}
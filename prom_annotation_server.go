@@ -3,6 +3,35 @@ package main
 /*
 	to add an annotation:
 		curl -XPUT -d '{"message":"build: web server", "tags": ["build"] }'  "localhost:9119/annotations"
+
+	for things that take a while, like maintenance windows, add the end time as well:
+		curl -XPUT -d '{"message":"db maintenance", "tags": ["maintenance"], "created_at": 1430797123, "ends_at": 1430800723 }'  "localhost:9119/annotations"
+
+	queries default to the last hour, range, until and from take durations, RFC3339 or now-<duration>, see times.go:
+		curl "localhost:9119/annotations?tags[]=build&from=now-2d&until=now-1d"
+
+	tags can contain * wildcards or be selected by regex, see tag_patterns.go:
+		curl "localhost:9119/annotations?tags[]=build-*&tag_regex=^deploy-(api|web)$"
+
+	op=and returns the annotations with all of the tags, op=or those with any of them. either way each is returned once with all its tags:
+		curl "localhost:9119/annotations?tags[]=prod&tags[]=api&op=and"
+
+	annotations with labels can be queried with PromQL-style selectors, see matchers.go:
+		curl -G --data-urlencode 'match[]={service="api",env=~"prod|staging"}' "localhost:9119/annotations"
+
+	results can be paged with limit, order and the returned cursor, see pagination.go:
+		curl "localhost:9119/annotations?all=1&limit=100&order=desc"
+
+	q searches the message and metadata of annotations, alone or combined with the above, see search.go:
+		curl "localhost:9119/annotations?q=payments+migration"
+
+	new annotations can be streamed as server-sent events, see stream.go:
+		curl -N "localhost:9119/annotations/stream?tags[]=deploy-*"
+	or followed over a WebSocket at /annotations/ws with subscriptions that can be changed on the fly, see websocket.go
+
+	to change or delete it again, using the id returned by the PUT request:
+		curl -XPATCH -d '{"tags": ["build", "web"] }'  "localhost:9119/annotations/<id>"
+		curl -XDELETE "localhost:9119/annotations/<id>"
 */
 
 import (
@@ -14,7 +43,9 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
+	"regexp"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -26,19 +57,36 @@ var (
 	/*
 		storage config is of format "type:type_specific_config"
 		for local storage we use boltdb to store the data and "type_specific_config" sets the name of the DB file
-		currently only "local" is available for storage type
 		for rethinkdb use this format: rethinkdb:<HOST:PORT>/<DBNAME>
+		for sqlite use "sqlite:<PATH TO DB FILE>"
+		for an append-only JSON-lines file use "jsonl:<PATH TO FILE>"
+		for in-memory storage use "memory:", nothing is persisted
 	*/
-	storageConfig   = flag.String("storage", "local:/tmp/annotations.db", "Storage config, format is \"type:options\". \"local\" and \"rethinkdb\" are currently the only supported types.")
-	listenAddress   = flag.String("listen-addr", ":9119", "Address to listen on for web interface")
-	annoEndpoint    = flag.String("endpoint", "/annotations", "Path under which to expose the annotation server")
-	metricsEndpoint = flag.String("metris", "/metrics", "Path under which to expose the metrics of the annotation server")
-	showVersion     = flag.Bool("version", false, "Show version information")
+	storageConfig      = flag.String("storage", "local:/tmp/annotations.db", "Storage config, format is \"type:options\". \"local\", \"sqlite\", \"jsonl\", \"rethinkdb\" and \"memory\" are currently the supported types.")
+	listenAddress      = flag.String("listen-addr", ":9119", "Address to listen on for web interface")
+	annoEndpoint       = flag.String("endpoint", "/annotations", "Path under which to expose the annotation server")
+	tagsEndpoint       = flag.String("tags-endpoint", "/tags", "Path under which to expose the tag management endpoint")
+	metricsEndpoint    = flag.String("metris", "/metrics", "Path under which to expose the metrics of the annotation server")
+	retention          = flag.Duration("retention", 0, "Delete annotations older than this, 0 keeps them forever")
+	retentionTags      = flag.String("retention-tags", "", "Per-tag retention overrides, format is \"tag=duration,tag2=duration\". An override deletes the whole annotation, including its copies under other tags with longer retention")
+	retentionEvery     = flag.Duration("retention-interval", 10*time.Minute, "How often to check for expired annotations")
+	maxRange           = flag.Duration("max-annotation-range", 30*24*time.Hour, "Longest time an annotation may cover from created_at to ends_at, range queries have to look back that far")
+	grafanaEndpoint    = flag.String("grafana-endpoint", "/grafana", "Path under which to expose the Grafana SimpleJSON datasource")
+	grafanaAPIEndpoint = flag.String("grafana-api-endpoint", "/api/annotations", "Path under which to emulate Grafana's HTTP annotations API")
+	hooksEndpoint      = flag.String("hooks-endpoint", "/hooks", "Path under which to expose the webhook receivers")
+	adminEndpoint      = flag.String("admin-endpoint", "/admin", "Path under which to expose admin functions like backups")
+	restoreFrom        = flag.String("restore-from", "", "Restore the local storage DB file from this backup before starting")
+	migrateTo          = flag.String("migrate-to", "", "Copy all annotations from --storage to this storage config and exit")
+	showVersion        = flag.Bool("version", false, "Show version information")
 )
 
 type ServerContext struct {
 	storage         Storage
 	annotationStats *prometheus.GaugeVec
+	expiredStats    *prometheus.CounterVec
+	seenAlerts      *seenAlerts
+	customHooks     map[string]*customHook
+	broker          *broker
 }
 
 func newAnnotationStats() *prometheus.GaugeVec {
@@ -58,7 +106,11 @@ func NewServerContext(storage string) (*ServerContext, error) {
 	srvr := ServerContext{
 		storage:         st,
 		annotationStats: newAnnotationStats(),
+		expiredStats:    newExpiredStats(),
+		seenAlerts:      newSeenAlerts(),
+		broker:          newBroker(),
 	}
+	srvr.watchStorage()
 	prometheus.MustRegister(&srvr)
 	return &srvr, nil
 }
@@ -67,11 +119,31 @@ func (s *ServerContext) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	log.Printf("Request: %s  %s", req.Method, req.URL.Path)
 
-	switch req.URL.Path {
-	case *metricsEndpoint:
+	switch {
+	case req.URL.Path == *metricsEndpoint:
 		prometheus.Handler().ServeHTTP(w, req)
-	case *annoEndpoint:
+	case req.URL.Path == *annoEndpoint:
 		prometheus.InstrumentHandlerFunc(*annoEndpoint, s.annotations)(w, req)
+	case req.URL.Path == *annoEndpoint+"/export":
+		prometheus.InstrumentHandlerFunc(*annoEndpoint+"/export", s.export)(w, req)
+	case req.URL.Path == *annoEndpoint+"/import":
+		prometheus.InstrumentHandlerFunc(*annoEndpoint+"/import", s.importAnnotations)(w, req)
+	case req.URL.Path == *annoEndpoint+"/stream":
+		prometheus.InstrumentHandlerFunc(*annoEndpoint+"/stream", s.stream)(w, req)
+	case req.URL.Path == *annoEndpoint+"/ws":
+		prometheus.InstrumentHandlerFunc(*annoEndpoint+"/ws", s.ws)(w, req)
+	case strings.HasPrefix(req.URL.Path, *annoEndpoint+"/"):
+		prometheus.InstrumentHandlerFunc(*annoEndpoint+"/:id", s.annotation)(w, req)
+	case req.URL.Path == *grafanaEndpoint || strings.HasPrefix(req.URL.Path, *grafanaEndpoint+"/"):
+		prometheus.InstrumentHandlerFunc(*grafanaEndpoint, s.grafana)(w, req)
+	case req.URL.Path == *grafanaAPIEndpoint || strings.HasPrefix(req.URL.Path, *grafanaAPIEndpoint+"/"):
+		prometheus.InstrumentHandlerFunc(*grafanaAPIEndpoint, s.grafanaAPI)(w, req)
+	case strings.HasPrefix(req.URL.Path, *hooksEndpoint+"/"):
+		prometheus.InstrumentHandlerFunc(*hooksEndpoint, s.hooks)(w, req)
+	case strings.HasPrefix(req.URL.Path, *adminEndpoint+"/"):
+		prometheus.InstrumentHandlerFunc(*adminEndpoint, s.admin)(w, req)
+	case req.URL.Path == *tagsEndpoint || strings.HasPrefix(req.URL.Path, *tagsEndpoint+"/"):
+		prometheus.InstrumentHandlerFunc(*tagsEndpoint, s.tags)(w, req)
 	default:
 		http.Error(w, "Not found", 404)
 	}
@@ -79,9 +151,12 @@ func (s *ServerContext) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 func (s *ServerContext) Describe(ch chan<- *prometheus.Desc) {
 	s.annotationStats.Describe(ch)
+	s.expiredStats.Describe(ch)
 }
 
 func (s *ServerContext) Collect(ch chan<- prometheus.Metric) {
+	s.expiredStats.Collect(ch)
+
 	s.annotationStats = newAnnotationStats()
 	defer s.annotationStats.Collect(ch)
 
@@ -118,18 +193,44 @@ func (s *ServerContext) annotations(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// checkTimes defaults created_at to now and converts milliseconds to seconds,
+// on errors it returns the result to respond with
+func checkTimes(a *Annotation) (result string, err error) {
+	if a.CreatedAt == 0 {
+		a.CreatedAt = int(time.Now().Unix())
+	}
+	if a.CreatedAt, err = EpochSeconds(a.CreatedAt); err == nil && a.EndsAt != 0 {
+		a.EndsAt, err = EpochSeconds(a.EndsAt)
+	}
+	if err != nil {
+		return "invalid_time", err
+	}
+	if a.EndsAt != 0 && a.EndsAt < a.CreatedAt {
+		return "invalid_range", fmt.Errorf("ends_at %d is before created_at %d", a.EndsAt, a.CreatedAt)
+	}
+	if d := time.Duration(a.End()-a.CreatedAt) * time.Second; d > *maxRange {
+		return "invalid_range", fmt.Errorf("the annotation covers %s, at most %s are allowed", d, *maxRange)
+	}
+	return "", nil
+}
+
 func (s *ServerContext) put(w http.ResponseWriter, req *http.Request) {
 
 	defer req.Body.Close()
 	body, _ := ioutil.ReadAll(req.Body)
 	var a Annotation
 	if err := json.Unmarshal(body, &a); err == nil {
-		if a.CreatedAt == 0 {
-			a.CreatedAt = int(time.Now().Unix())
+		if result, err := checkTimes(&a); err != nil {
+			writeJSON(w, 400, map[string]string{"result": result, "error": err.Error()})
+			return
+		}
+		if err := checkReservedTags(a.Tags); err != nil {
+			writeJSON(w, 400, map[string]string{"result": "invalid_tag", "error": err.Error()})
+			return
 		}
 
-		if err := s.storage.Add(a); err == nil {
-			writeJSON(w, 200, map[string]string{"result": "ok"})
+		if id, err := s.add(a); err == nil {
+			writeJSON(w, 200, map[string]string{"result": "ok", "id": id})
 			return
 		}
 	}
@@ -138,6 +239,91 @@ func (s *ServerContext) put(w http.ResponseWriter, req *http.Request) {
 	writeJSON(w, 500, map[string]string{"result": "invalid_json"})
 }
 
+func (s *ServerContext) annotation(w http.ResponseWriter, req *http.Request) {
+
+	id := strings.TrimPrefix(req.URL.Path, *annoEndpoint+"/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Not found", 404)
+		return
+	}
+
+	switch req.Method {
+
+	case "PATCH":
+		s.patch(w, req, id)
+
+	case "DELETE":
+		s.delete(w, req, id)
+
+	default:
+		http.Error(w, "Not supported", 405)
+	}
+}
+
+func (s *ServerContext) patch(w http.ResponseWriter, req *http.Request, id string) {
+
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	var p AnnotationPatch
+	err := json.Unmarshal(body, &p)
+	if err != nil {
+		log.Printf("unmarshal patch error: %s", body)
+		writeJSON(w, 400, map[string]string{"result": "invalid_json"})
+		return
+	}
+
+	result, err := s.checkPatch(id, &p)
+	if result != "" {
+		writeJSON(w, 400, map[string]string{"result": result, "error": err.Error()})
+		return
+	}
+	var a Annotation
+	if err == nil {
+		a, err = s.storage.Update(id, p)
+	}
+	switch err {
+	case nil:
+		writeJSON(w, 200, a.inMillis())
+	case ErrNotFound:
+		writeJSON(w, 404, map[string]string{"result": "not_found"})
+	default:
+		log.Printf("update annotation %s err: %s", id, err)
+		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+	}
+}
+
+// checkPatch checks the times of annotation id with p applied like on PUT and sets both of them in p,
+// so a concurrent patch of the other one can't leave an invalid range. result is only set for invalid times.
+func (s *ServerContext) checkPatch(id string, p *AnnotationPatch) (result string, err error) {
+	if p.CreatedAt == nil && p.EndsAt == nil {
+		return "", nil
+	}
+	a, err := s.storage.Get(id)
+	if err != nil {
+		return "", err
+	}
+	p.Apply(&a)
+	if result, err = checkTimes(&a); err != nil {
+		return result, err
+	}
+	p.CreatedAt, p.EndsAt = &a.CreatedAt, &a.EndsAt
+	return "", nil
+}
+
+func (s *ServerContext) delete(w http.ResponseWriter, req *http.Request, id string) {
+
+	err := s.storage.Delete(id)
+	switch err {
+	case nil:
+		writeJSON(w, 200, map[string]string{"result": "ok"})
+	case ErrNotFound:
+		writeJSON(w, 404, map[string]string{"result": "not_found"})
+	default:
+		log.Printf("delete annotation %s err: %s", id, err)
+		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+	}
+}
+
 func (s *ServerContext) get(w http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
 	var err error
@@ -149,21 +335,118 @@ func (s *ServerContext) get(w http.ResponseWriter, req *http.Request) {
 		tags = s.storage.AllTags()
 		r = int(time.Now().Unix())
 	} else {
-		r, _ = strconv.Atoi(req.Form.Get("range"))
-		if r == 0 {
-			r = 3600
+		if r, until, err = ParseQueryRange(req.Form, time.Now()); err != nil {
+			writeJSON(w, 400, map[string]string{"result": "invalid_time", "error": err.Error()})
+			return
+		}
+		var regexes []*regexp.Regexp
+		for _, expr := range req.Form["tag_regex"] {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				writeJSON(w, 400, map[string]string{"result": "invalid_tag_regex", "error": err.Error()})
+				return
+			}
+			regexes = append(regexes, re)
+		}
+		tags, err = ExpandTags(s.storage, req.Form["tags[]"], regexes, *tagPatternLimit)
+		if _, ok := err.(ErrTooManyTags); ok {
+			writeJSON(w, 400, map[string]string{"result": "too_many_tags", "error": err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+			return
 		}
-		until, _ = strconv.Atoi(req.Form.Get("until"))
-		tags, _ = req.Form["tags[]"]
 	}
 	if until == 0 {
 		until = int(time.Now().Unix())
 	}
-	list, err := GetPosts(s.storage, tags, r, until)
+
+	var selectors [][]*LabelMatcher
+	for _, m := range req.Form["match[]"] {
+		ms, err := ParseMatchers(m)
+		if err != nil {
+			writeJSON(w, 400, map[string]string{"result": "invalid_matcher", "error": err.Error()})
+			return
+		}
+		selectors = append(selectors, ms)
+	}
+
+	// without op every annotation is returned once per queried tag, with just that tag
+	op := req.Form.Get("op")
+	var tagOp TagOp
+	if op != "" {
+		if tagOp, err = ParseTagOp(op); err != nil {
+			writeJSON(w, 400, map[string]string{"result": "invalid_op", "error": err.Error()})
+			return
+		}
+	}
+
+	opts, paged, err := ParseListOpts(req.Form)
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"result": "invalid_pagination", "error": err.Error()})
+		return
+	}
+	terms := searchTerms(req.Form.Get("q"))
+
+	var list Posts
+	switch {
+	case op != "":
+		list, err = GetPostsForTags(s.storage, tags, tagOp, r, until)
+	case paged && len(terms) == 0:
+		// tags are only read as far as the page needs, the search below would filter out too much
+		list, err = GetPage(s.storage, tags, r, until, opts)
+	default:
+		list, err = GetPosts(s.storage, tags, r, until)
+	}
 	if err != nil {
 		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
 		return
 	}
+	if len(selectors) > 0 {
+		matched, err := GetPostsForMatchers(s.storage, selectors, r, until)
+		if err != nil {
+			writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+			return
+		}
+		seen := make(map[string]bool)
+		if op != "" {
+			for _, a := range list.Posts {
+				seen[a.ID] = true
+			}
+		}
+		for _, a := range matched {
+			if !seen[a.ID] {
+				list.Posts = append(list.Posts, a)
+			}
+		}
+	}
+
+	if len(terms) > 0 {
+		// without any other criteria the storage searches all annotations, otherwise the results are filtered
+		if all == "" && len(req.Form["tags[]"]) == 0 && len(req.Form["tag_regex"]) == 0 && len(selectors) == 0 {
+			err = s.storage.Search(terms, r, until, &list.Posts)
+			if err != nil {
+				writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+				return
+			}
+		} else {
+			found := list.Posts[:0]
+			for _, a := range list.Posts {
+				if a.MatchesTerms(terms) {
+					found = append(found, a)
+				}
+			}
+			list.Posts = found
+		}
+	}
+
+	if paged {
+		var next *Cursor
+		if list.Posts, next = Paginate(list.Posts, opts); next != nil {
+			list.Cursor = EncodeCursor(*next)
+		}
+	}
 
 	writeJSON(w, 200, list)
 }
@@ -175,19 +458,63 @@ func main() {
 		return
 	}
 
+	if *migrateTo != "" {
+		if err := runMigration(*storageConfig, *migrateTo); err != nil {
+			log.Fatalf("migration failed, err: %s", err)
+		}
+		return
+	}
+
+	if *restoreFrom != "" {
+		parts := strings.SplitN(*storageConfig, ":", 2)
+		if len(parts) != 2 || parts[0] != "local" {
+			log.Fatalf("--restore-from is only supported for local storage")
+		}
+		if err := RestoreBoltDB(*restoreFrom, parts[1]); err != nil {
+			log.Fatalf("restore failed, err: %s", err)
+		}
+		log.Printf("Restored %s from %s", parts[1], *restoreFrom)
+	}
+
+	policy, err := ParseRetentionPolicy(*retention, *retentionTags)
+	if err != nil {
+		log.Fatalf("retention config borked, err: %s", err)
+	}
+
 	ctx, err := NewServerContext(*storageConfig)
 	if err != nil {
 		log.Fatalf("storage config borked, err: %s", err)
 	}
 	defer ctx.storage.Close()
 
+	if *hooksConfig != "" {
+		if ctx.customHooks, err = LoadCustomHooks(*hooksConfig); err != nil {
+			log.Fatalf("hooks config borked, err: %s", err)
+		}
+		log.Printf("Loaded %d custom hooks from %s", len(ctx.customHooks), *hooksConfig)
+	}
+
+	if policy.Enabled() {
+		go ctx.runReaper(policy, *retentionEvery)
+	}
+
 	http.Handle("/", ctx)
 
 	log.Printf("Running server listening at %s, ", *listenAddress)
 	go http.ListenAndServe(*listenAddress, nil)
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	signal.Notify(c, os.Interrupt, syscall.SIGHUP)
+	// SIGHUP compacts storages like the JSON-lines file, e.g. after logrotate moved it away
+	for sig := range c {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		if cs, ok := ctx.storage.(Compacter); ok {
+			if err := cs.Compact(); err != nil {
+				log.Printf("compact err: %s", err)
+			}
+		}
+	}
 	log.Printf("Exiting")
 }
@@ -63,6 +63,25 @@ func (s *TestSetup) putJSON(msg string, expectedStatus int) error {
 	return err
 }
 
+// putID adds an annotation and returns the id assigned by the server
+func (s *TestSetup) putID(msg string) (string, error) {
+	request, err := http.NewRequest("PUT", s.Server.URL+"/annotations", strings.NewReader(msg))
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var added map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&added); err != nil {
+		return "", err
+	}
+	if added["id"] == "" {
+		return "", fmt.Errorf("missing id in response: %#v", added)
+	}
+	return added["id"], nil
+}
+
 func (s *TestSetup) query(tag string, ts int) (p Posts, err error) {
 	queryURL := fmt.Sprintf("%s/annotations?until=%d&range=3600&tags[]=%s", s.Server.URL, ts, tag)
 	return s.queryURL(queryURL)
@@ -221,6 +240,160 @@ func (s *TestSetup) testMetrics() {
 	}
 }
 
+func (s *TestSetup) testDelete() {
+	ts := int(time.Now().Unix())
+	id, err := s.putID(fmt.Sprintf(`{"created_at": %d, "message": "delete me", "tags": ["deltag"]}`, ts))
+	if err != nil {
+		s.T.Error(err)
+		return
+	}
+
+	for _, expectedStatus := range []int{200, 404} {
+		request, _ := http.NewRequest("DELETE", s.Server.URL+"/annotations/"+id, nil)
+		res, err := http.DefaultClient.Do(request)
+		if err != nil {
+			s.T.Errorf("err: %s", err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != expectedStatus {
+			s.T.Errorf("Expected code of %d, not: %d", expectedStatus, res.StatusCode)
+		}
+	}
+
+	if l, err := s.query("deltag", ts); err != nil || len(l.Posts) != 0 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+}
+
+func (s *TestSetup) testPatch() {
+	ts := int(time.Now().Unix())
+	id, err := s.putID(fmt.Sprintf(`{"created_at": %d, "message": "typo", "tags": ["patchtag"]}`, ts))
+	if err != nil {
+		s.T.Error(err)
+		return
+	}
+
+	for _, tc := range []struct {
+		id, body       string
+		expectedStatus int
+	}{
+		{id, `{"message": "fixed", "tags": ["patchtag", "patchtag2"]}`, 200},
+		{id, `{ BROKEN_JSON }`, 400},
+		// the range is checked against the stored created_at
+		{id, fmt.Sprintf(`{"ends_at": %d}`, ts-60), 400},
+		{id, fmt.Sprintf(`{"ends_at": %d}`, (ts+60)*1000), 200},
+		{id, fmt.Sprintf(`{"created_at": %d}`, ts+120), 400},
+		{"does-not-exist", `{"message": "fixed"}`, 404},
+		{"does-not-exist", fmt.Sprintf(`{"ends_at": %d}`, ts), 404},
+	} {
+		request, _ := http.NewRequest("PATCH", s.Server.URL+"/annotations/"+tc.id, strings.NewReader(tc.body))
+		res, err := http.DefaultClient.Do(request)
+		if err != nil {
+			s.T.Errorf("err: %s", err)
+			return
+		}
+		var a Annotation
+		json.NewDecoder(res.Body).Decode(&a)
+		res.Body.Close()
+		if res.StatusCode != tc.expectedStatus {
+			s.T.Errorf("Expected code of %d, not: %d", tc.expectedStatus, res.StatusCode)
+		}
+		// the updated annotation is returned like query results, in milliseconds
+		if res.StatusCode == 200 && (a.Message != "fixed" || a.CreatedAt != ts*1000) {
+			s.T.Errorf("Wrong response: %#v", a)
+		}
+	}
+
+	// like on PUT, a zero created_at means now
+	request, _ := http.NewRequest("PATCH", s.Server.URL+"/annotations/"+id, strings.NewReader(`{"created_at": 0}`))
+	if res, err := http.DefaultClient.Do(request); err != nil {
+		s.T.Errorf("err: %s", err)
+	} else {
+		var a Annotation
+		json.NewDecoder(res.Body).Decode(&a)
+		res.Body.Close()
+		if res.StatusCode != 200 || a.CreatedAt < ts*1000 || a.EndsAt != (ts+60)*1000 {
+			s.T.Errorf("Wrong response: %d %#v", res.StatusCode, a)
+		}
+	}
+
+	l, err := s.query("patchtag2", ts)
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "fixed" {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+}
+
+func (s *TestSetup) testRanges() {
+	ts := int(time.Now().Unix())
+	if err := s.putJSON(fmt.Sprintf(`{"created_at": %d, "ends_at": %d, "message": "maintenance", "tags": ["rangetag"]}`, ts-7200, ts-60), 200); err != nil {
+		s.T.Error(err)
+	}
+	s.putJSON(fmt.Sprintf(`{"created_at": %d, "ends_at": %d, "message": "backwards", "tags": ["rangetag"]}`, ts, ts-60), 400)
+	s.putJSON(fmt.Sprintf(`{"created_at": %d, "ends_at": %d, "message": "too long", "tags": ["rangetag"]}`, ts, ts+int(maxRange.Seconds())+1), 400)
+
+	l, err := s.query("rangetag", ts)
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].CreatedAt != (ts-7200)*1000 || l.Posts[0].EndsAt != (ts-60)*1000 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	if l, err := s.query("rangetag", ts-7100); err != nil || len(l.Posts) != 1 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	if l, err := s.query("rangetag", ts+3590); err != nil || len(l.Posts) != 0 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+}
+
+func (s *TestSetup) testMetadata() {
+	ts := int(time.Now().Unix())
+	if err := s.putJSON(fmt.Sprintf(`{"created_at": %d, "message": "deployed", "tags": ["metatag"], "author": "jane", "source": "ci", "url": "http://ci/builds/1", "severity": "info", "labels": {"env": "prod"}}`, ts), 200); err != nil {
+		s.T.Error(err)
+	}
+
+	l, err := s.query("metatag", ts)
+	if err != nil || len(l.Posts) != 1 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+		return
+	}
+	if a := l.Posts[0]; a.Author != "jane" || a.Source != "ci" || a.URL != "http://ci/builds/1" || a.Severity != "info" || a.Labels["env"] != "prod" {
+		s.T.Errorf("Wrong metadata: %#v", a)
+	}
+}
+
+func (s *TestSetup) testTagOps() {
+	ts := int(time.Now().Unix())
+	if err := s.putJSON(fmt.Sprintf(`{"created_at": %d, "message": "both", "tags": ["optag1", "optag2"]}`, ts), 200); err != nil {
+		s.T.Error(err)
+	}
+	if err := s.putJSON(fmt.Sprintf(`{"created_at": %d, "message": "one", "tags": ["optag1"]}`, ts), 200); err != nil {
+		s.T.Error(err)
+	}
+
+	// without op the annotation with both tags is returned twice
+	l, err := s.queryURL(fmt.Sprintf("%s/annotations?until=%d&range=60&tags[]=optag1&tags[]=optag2", s.Server.URL, ts))
+	if err != nil || len(l.Posts) != 3 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	l, err = s.queryURL(fmt.Sprintf("%s/annotations?until=%d&range=60&tags[]=optag1&tags[]=optag2&op=or", s.Server.URL, ts))
+	if err != nil || len(l.Posts) != 2 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	l, err = s.queryURL(fmt.Sprintf("%s/annotations?until=%d&range=60&tags[]=optag1&tags[]=optag2&op=and", s.Server.URL, ts))
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "both" || len(l.Posts[0].Tags) != 2 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+
+	res, err := http.Get(fmt.Sprintf("%s/annotations?tags[]=optag1&op=xor", s.Server.URL))
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != 400 {
+		s.T.Errorf("Expected code of 400, not: %d", res.StatusCode)
+	}
+}
+
 func (s *TestSetup) testAllTags() {
 	tagsPre := s.Ctx.storage.AllTags()
 	if err := s.put("msg1", "xxxtag1", 0); err != nil {
@@ -242,6 +415,9 @@ func TestServer(t *testing.T) {
 	ts := int(time.Now().Unix())
 	storageToTest := []string{
 		fmt.Sprintf("local:./test-%d.db", ts),
+		fmt.Sprintf("sqlite:./test-%d.sqlite", ts),
+		fmt.Sprintf("jsonl:./test-%d.jsonl", ts),
+		"memory:",
 		fmt.Sprintf("rethinkdb:localhost:28015/annotst%d", ts),
 	}
 
@@ -257,6 +433,28 @@ func TestServer(t *testing.T) {
 		s.testMetrics()
 		s.testAllTags()
 		s.testAll()
+		s.testDelete()
+		s.testPatch()
+		s.testRanges()
+		s.testMetadata()
+		s.testTagOps()
+		s.testPaging()
+		s.testTimes()
+		s.testMatchers()
+		s.testTagPatterns()
+		s.testSearch()
+		s.testTags()
+		s.testRetention()
+		s.testExportImport()
+		s.testBackup()
+		s.testCompact()
+		s.testGrafana()
+		s.testGrafanaAPI()
+		s.testAlertmanagerHook()
+		s.testVCSHooks()
+		s.testCustomHooks()
+		s.testStream()
+		s.testWebSocket()
 
 		s.Server.Close()
 		s.Ctx.storage.Cleanup()
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RetentionPolicy holds the maximum age of annotations, the default applies to all tags without an override, e.g.
+//
+//	--retention=720h --retention-tags="deploy=8760h,ci=24h"
+//
+// a zero age keeps annotations forever, untagged annotations expire with the default.
+// an annotation with several tags is deleted as soon as one of its tags expires it, so a short
+// override deletes it along with its copies under tags that keep annotations longer.
+type RetentionPolicy struct {
+	Default time.Duration
+	Tags    map[string]time.Duration
+}
+
+func ParseRetentionPolicy(def time.Duration, tags string) (RetentionPolicy, error) {
+	p := RetentionPolicy{Default: def, Tags: make(map[string]time.Duration)}
+	if tags == "" {
+		return p, nil
+	}
+
+	for _, opt := range strings.Split(tags, ",") {
+		parts := strings.SplitN(opt, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return p, fmt.Errorf("invalid retention \"%s\", expected format: <tag>=<duration>", opt)
+		}
+		d, err := time.ParseDuration(parts[1])
+		if err != nil {
+			return p, fmt.Errorf("invalid retention for tag %s: %s", parts[0], err)
+		}
+		p.Tags[parts[0]] = d
+	}
+	return p, nil
+}
+
+func (p RetentionPolicy) For(tag string) time.Duration {
+	if d, ok := p.Tags[tag]; ok {
+		return d
+	}
+	return p.Default
+}
+
+func (p RetentionPolicy) Enabled() bool {
+	if p.Default > 0 {
+		return true
+	}
+	for _, d := range p.Tags {
+		if d > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func newExpiredStats() *prometheus.CounterVec {
+
+	return prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "annotations_expired_total",
+		Help: "Number of annotations deleted by the retention policy per tag, untagged ones have an empty tag.",
+	}, []string{"tag"})
+}
+
+// expire deletes all annotations that are older than the policy allows and returns the number of deleted annotations
+func (s *ServerContext) expire(p RetentionPolicy, now time.Time) (total int) {
+	// the storages select untagged annotations for the empty tag, they expire with the default
+	for _, tag := range append(s.storage.AllTags(), "") {
+		age := p.For(tag)
+		if age <= 0 {
+			continue
+		}
+
+		count, err := s.storage.Expire(tag, int(now.Add(-age).Unix()))
+		if err != nil {
+			log.Printf("expire tag %q err: %s", tag, err)
+			continue
+		}
+		if count > 0 {
+			s.expiredStats.WithLabelValues(tag).Add(float64(count))
+			total += count
+		}
+	}
+	return total
+}
+
+// runReaper expires annotations every interval, it never returns
+func (s *ServerContext) runReaper(p RetentionPolicy, interval time.Duration) {
+	for {
+		if count := s.expire(p, time.Now()); count > 0 {
+			log.Printf("Expired %d annotations", count)
+		}
+		time.Sleep(interval)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	invalid := []string{"deploy", "=1h", "deploy=1x", "deploy=1h,ci"}
+	for _, opt := range invalid {
+		if _, err := ParseRetentionPolicy(0, opt); err == nil {
+			t.Errorf("no good, expected error for %s", opt)
+		}
+	}
+
+	p, err := ParseRetentionPolicy(time.Hour, "deploy=24h,ci=0s")
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	if p.For("deploy") != 24*time.Hour || p.For("ci") != 0 || p.For("other") != time.Hour {
+		t.Errorf("no good, wrong policy: %#v", p)
+	}
+	if !p.Enabled() {
+		t.Errorf("no good, policy should be enabled")
+	}
+
+	if p, _ := ParseRetentionPolicy(0, ""); p.Enabled() {
+		t.Errorf("no good, policy should be disabled")
+	}
+}
+
+func TestExpireUntagged(t *testing.T) {
+	now := time.Now()
+	ts := int(now.Unix())
+	st := NewMemoryStorage()
+	defer st.Cleanup()
+	ctx := &ServerContext{storage: st, expiredStats: newExpiredStats()}
+
+	st.Add(Annotation{CreatedAt: ts - 7200, Message: "old untagged"})
+	st.Add(Annotation{CreatedAt: ts - 7200, Message: "old tagged", Tags: []string{"keep"}})
+	st.Add(Annotation{CreatedAt: ts - 60, Message: "new untagged"})
+
+	p := RetentionPolicy{Default: time.Hour, Tags: map[string]time.Duration{"keep": 0}}
+	if count := ctx.expire(p, now); count != 1 {
+		t.Errorf("no good, wrong number of expired annotations: %d", count)
+	}
+
+	var list []Annotation
+	if err := st.List(3*3600, ts, ListOpts{}, &list); err != nil || len(list) != 2 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+		return
+	}
+	if list[0].Message != "old tagged" || list[1].Message != "new untagged" {
+		t.Errorf("no good, wrong annotations left: %#v", list)
+	}
+}
+
+func (s *TestSetup) testRetention() {
+	now := time.Now()
+	ts := int(now.Unix())
+	s.put("msg1", "retention1", ts-7200)
+	s.put("msg2", "retention1", ts-60)
+	s.put("msg3", "retention2", ts-7200)
+	s.put("msg4", "retention3", ts-7200)
+
+	p := RetentionPolicy{Tags: map[string]time.Duration{"retention1": time.Hour, "retention2": 3 * time.Hour}}
+	if count := s.Ctx.expire(p, now); count != 1 {
+		s.T.Errorf("wrong number of expired annotations: %d", count)
+	}
+
+	stats, _ := s.Ctx.storage.TagStats()
+	if stats["retention1"] != 1 || stats["retention2"] != 1 || stats["retention3"] != 1 {
+		s.T.Errorf("wrong stats after expiry: %#v", stats)
+	}
+
+	res, err := http.Get(s.Server.URL + *metricsEndpoint)
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+
+	want := fmt.Sprintf(`annotations_expired_total{tag="%s"} 1`, "retention1")
+	if !strings.Contains(string(body), want) {
+		s.T.Errorf(`missing "%s" from metrics`, want)
+	}
+}
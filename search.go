@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+/*
+	full-text search over the message and metadata of annotations:
+		curl "localhost:9119/annotations?q=payments+migration&range=1y"
+	the search is case-insensitive and matches whole words, an annotation has to contain all of them.
+	message, author, source, severity and label values are searched.
+*/
+
+// searchTerms splits s into lower case words
+func searchTerms(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchText returns the text of a that is searched
+func (a Annotation) searchText() string {
+	parts := []string{a.Message, a.Author, a.Source, a.Severity}
+	names := make([]string, 0, len(a.Labels))
+	for name := range a.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, a.Labels[name])
+	}
+	return strings.Join(parts, " ")
+}
+
+// searchWords returns the distinct words of the searched text of a
+func (a Annotation) searchWords() (res []string) {
+	seen := make(map[string]bool)
+	for _, w := range searchTerms(a.searchText()) {
+		if !seen[w] {
+			seen[w] = true
+			res = append(res, w)
+		}
+	}
+	return res
+}
+
+// MatchesTerms reports whether a contains all of the lower case terms
+func (a Annotation) MatchesTerms(terms []string) bool {
+	words := make(map[string]bool)
+	for _, w := range searchTerms(a.searchText()) {
+		words[w] = true
+	}
+	for _, t := range terms {
+		if !words[t] {
+			return false
+		}
+	}
+	return true
+}
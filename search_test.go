@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSearchTerms(t *testing.T) {
+	if terms := searchTerms("Payments-API  migration, v2!"); !reflect.DeepEqual(terms, []string{"payments", "api", "migration", "v2"}) {
+		t.Errorf("no good, got %#v", terms)
+	}
+	if terms := searchTerms(" ,. "); len(terms) != 0 {
+		t.Errorf("no good, got %#v", terms)
+	}
+}
+
+func TestMatchesTerms(t *testing.T) {
+	a := Annotation{Message: "Deploy of Über-API", Author: "alice", Severity: "warning", Labels: map[string]string{"service": "payments"}}
+	for q, expected := range map[string]bool{
+		"deploy":           true,
+		"DEPLOY über":      true,
+		"alice warning":    true,
+		"payments deploy":  true,
+		"service":          false,
+		"dep":              false,
+		"deploy rollback":  false,
+		"api-deploy ALICE": true,
+	} {
+		if a.MatchesTerms(searchTerms(q)) != expected {
+			t.Errorf("no good, %s should return %t", q, expected)
+		}
+	}
+}
+
+func (s *TestSetup) testSearch() {
+	ts := int(time.Now().Unix())
+	for _, body := range []string{
+		`{"created_at": %d, "message": "Searchable payments migration", "tags": ["searchtag1"]}`,
+		`{"created_at": %d, "message": "searchable deploy", "tags": ["searchtag2"], "author": "searchbot"}`,
+	} {
+		if err := s.putJSON(fmt.Sprintf(body, ts), 200); err != nil {
+			s.T.Error(err)
+		}
+	}
+
+	l, err := s.queryURL(fmt.Sprintf("%s/annotations?until=%d&range=60&q=searchable+MIGRATION", s.Server.URL, ts))
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "Searchable payments migration" {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+
+	l, err = s.queryURL(fmt.Sprintf("%s/annotations?until=%d&range=60&q=searchbot", s.Server.URL, ts))
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "searchable deploy" {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+
+	// with tags the results are narrowed down
+	l, err = s.queryURL(fmt.Sprintf("%s/annotations?until=%d&range=60&q=searchable&tags[]=searchtag2", s.Server.URL, ts))
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].Message != "searchable deploy" {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+}
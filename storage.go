@@ -4,28 +4,150 @@ import (
 	"log"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 )
 
 type TagStats map[string]int
 
+var (
+	ErrNotFound    = errors.New("annotation not found")
+	ErrTagNotFound = errors.New("tag not found")
+)
+
 type Storage interface {
-	Add(a Annotation) error
-	ListForTag(tag string, r, until int, out *[]Annotation) (err error)
+	Add(a Annotation) (id string, err error)
+	Get(id string) (Annotation, error)
+	Delete(id string) error
+	Update(id string, p AnnotationPatch) (Annotation, error)
+	ListForTag(tag string, r, until int, opts ListOpts, out *[]Annotation) (err error) // annotations overlapping [until-r, until]
+	List(r, until int, opts ListOpts, out *[]Annotation) error                         // like ListForTag but regardless of tags, with all tags
+	ListForTags(tags []string, op TagOp, r, until int, out *[]Annotation) error        // like ListForTag but each annotation once, with all tags
+	ListForMatchers(ms []*LabelMatcher, r, until int, out *[]Annotation) error         // like ListForTags but by labels
+	Search(terms []string, r, until int, out *[]Annotation) error                      // like ListForTags but by words, see search.go
 	TagStats() (TagStats, error)
 	AllTags() []string
+	RenameTag(from, to string) error // merges into "to" if it already exists
+	DeleteTag(tag string) (int, error)
+	Expire(tag string, before int) (int, error) // deletes annotations with tag created before "before", untagged ones for ""
+	Walk(fn func(a Annotation) error) error     // calls fn for every annotation ordered by creation time, stops at the first error
 	Close()
 	Cleanup() // after tests
 }
 
+// Compacter is implemented by storages whose files keep growing with changes, Compact drops superseded data
+type Compacter interface {
+	Compact() error
+}
+
+// Backuper is implemented by storages that can write a consistent snapshot of their data while in use
+type Backuper interface {
+	Backup(w io.Writer) (int64, error)
+}
+
 type Annotation struct {
+	ID        string   `json:"id,omitempty"           gorethink:"id,omitempty"`
 	CreatedAt int      `json:"created_at,omitempty"   gorethink:"created_at"`
+	EndsAt    int      `json:"ends_at,omitempty"      gorethink:"ends_at,omitempty"` // 0 for annotations without duration
 	Message   string   `json:"message"                gorethink:"message"`
 	Tags      []string `json:"tags,omitempty"         gorethink:"tags"`
+
+	// optional metadata, Source is the system that created the annotation, e.g. "alertmanager"
+	Author   string            `json:"author,omitempty"     gorethink:"author,omitempty"`
+	Source   string            `json:"source,omitempty"     gorethink:"source,omitempty"`
+	URL      string            `json:"url,omitempty"        gorethink:"url,omitempty"`
+	Severity string            `json:"severity,omitempty"   gorethink:"severity,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"     gorethink:"labels,omitempty"`
+}
+
+// End returns the end of the annotation's time range, that's CreatedAt if it has no duration
+func (a Annotation) End() int {
+	if a.EndsAt > a.CreatedAt {
+		return a.EndsAt
+	}
+	return a.CreatedAt
+}
+
+// inMillis returns the copy of a that queries return, with times in milliseconds
+func (a Annotation) inMillis() Annotation {
+	res := a
+	res.CreatedAt = a.CreatedAt * 1000
+	res.EndsAt = a.EndsAt * 1000
+	res.Tags = append([]string{}, a.Tags...)
+	res.Labels = copyLabels(a.Labels)
+	return res
+}
+
+// forTag returns the copy of a that ListForTag returns for tag
+func (a Annotation) forTag(tag string) Annotation {
+	res := a.inMillis()
+	res.Tags = []string{tag}
+	return res
+}
+
+// Overlaps reports whether the annotation's time range overlaps [from, until]
+func (a Annotation) Overlaps(from, until int) bool {
+	return a.CreatedAt <= until && a.End() >= from
+}
+
+// AnnotationPatch holds the fields of an annotation that should be changed, nil fields are left alone
+type AnnotationPatch struct {
+	CreatedAt *int      `json:"created_at"   gorethink:"created_at,omitempty"`
+	EndsAt    *int      `json:"ends_at"      gorethink:"ends_at,omitempty"`
+	Message   *string   `json:"message"      gorethink:"message,omitempty"`
+	Tags      *[]string `json:"tags"         gorethink:"tags,omitempty"`
+
+	Author   *string            `json:"author"     gorethink:"author,omitempty"`
+	Source   *string            `json:"source"     gorethink:"source,omitempty"`
+	URL      *string            `json:"url"        gorethink:"url,omitempty"`
+	Severity *string            `json:"severity"   gorethink:"severity,omitempty"`
+	Labels   *map[string]string `json:"labels"     gorethink:"labels,omitempty"` // replaces all labels
+}
+
+func (p AnnotationPatch) Apply(a *Annotation) {
+	if p.CreatedAt != nil {
+		a.CreatedAt = *p.CreatedAt
+	}
+	if p.EndsAt != nil {
+		a.EndsAt = *p.EndsAt
+	}
+	if p.Message != nil {
+		a.Message = *p.Message
+	}
+	if p.Tags != nil {
+		a.Tags = *p.Tags
+	}
+	if p.Author != nil {
+		a.Author = *p.Author
+	}
+	if p.Source != nil {
+		a.Source = *p.Source
+	}
+	if p.URL != nil {
+		a.URL = *p.URL
+	}
+	if p.Severity != nil {
+		a.Severity = *p.Severity
+	}
+	if p.Labels != nil {
+		a.Labels = copyLabels(*p.Labels)
+	}
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(labels))
+	for k, v := range labels {
+		res[k] = v
+	}
+	return res
 }
 
 type Posts struct {
-	Posts []Annotation `json:"posts"`
+	Posts  []Annotation `json:"posts"`
+	Cursor string       `json:"cursor,omitempty"` // for the next page of paged queries
 }
 
 func NewStorage(config string) (Storage, error) {
@@ -45,6 +167,18 @@ func NewStorage(config string) (Storage, error) {
 		{
 			return NewRethinkDBStorage(parts[1])
 		}
+	case "sqlite":
+		{
+			return NewSQLiteStorage(parts[1])
+		}
+	case "jsonl":
+		{
+			return NewJSONLStorage(parts[1])
+		}
+	case "memory":
+		{
+			return NewMemoryStorage(), nil
+		}
 	}
 	return nil, fmt.Errorf("invalid config, type \"%s\" not supported", parts[0])
 }
@@ -52,9 +186,69 @@ func NewStorage(config string) (Storage, error) {
 func GetPosts(s Storage, tags []string, ra, until int) (res Posts, err error) {
 	res.Posts = make([]Annotation, 0)
 	for _, tag := range tags {
-		if s.ListForTag(tag, ra, until, &res.Posts) != nil {
+		if s.ListForTag(tag, ra, until, ListOpts{}, &res.Posts) != nil {
 			return res, err
 		}
 	}
 	return res, nil
 }
+
+// TagOp is how ListForTags combines several tags
+type TagOp int
+
+const (
+	TagOr  TagOp = iota // annotations with any of the tags
+	TagAnd              // annotations with all of the tags
+)
+
+func ParseTagOp(s string) (TagOp, error) {
+	switch strings.ToLower(s) {
+	case "or":
+		return TagOr, nil
+	case "and":
+		return TagAnd, nil
+	}
+	return TagOr, fmt.Errorf("invalid op \"%s\", use and or or", s)
+}
+
+// GetPostsForTags is like GetPosts but returns each annotation once, with all of its tags
+func GetPostsForTags(s Storage, tags []string, op TagOp, ra, until int) (res Posts, err error) {
+	res.Posts = make([]Annotation, 0)
+	if len(tags) == 0 {
+		return res, nil
+	}
+	// storages count how many of the tags an annotation has, so they have to be unique
+	err = s.ListForTags(uniqueTags(tags), op, ra, until, &res.Posts)
+	return res, err
+}
+
+// uniqueTags returns a copy of tags without duplicates, in the order they first appear
+func uniqueTags(tags []string) []string {
+	res := make([]string, 0, len(tags))
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		if !seen[tag] {
+			seen[tag] = true
+			res = append(res, tag)
+		}
+	}
+	return res
+}
+
+// renameTag replaces "from" with "to" in tags, without adding "to" twice
+func renameTag(tags []string, from, to string) []string {
+	res := make([]string, 0, len(tags))
+	for _, t := range tags {
+		if t == from {
+			t = to
+		}
+		dup := false
+		for _, r := range res {
+			dup = dup || r == t
+		}
+		if !dup {
+			res = append(res, t)
+		}
+	}
+	return res
+}
@@ -4,17 +4,43 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/boltdb/bolt"
 )
 
+/*
+	every tag has its own bucket holding a copy of each annotation with that tag,
+	keyed by creation time so range queries can use a cursor.
+	buckets starting with boltReservedPrefix are internal and never exposed as tags:
+	  - boltAllBucket holds the canonical copy of every annotation (with all its tags)
+	  - boltIDsBucket maps annotation IDs to their key in the other buckets
+	  - boltDurationsBucket indexes annotations with a duration by duration + "\x00" + their key, range queries
+	    have to start the longest duration earlier to find annotations that started before the range
+	  - boltWordsBucket is the full-text index, keyed by word + "\x00" + the annotation's key
+	  - boltUntaggedBucket is like a tag bucket for annotations without tags, so they can expire
+*/
+
+const (
+	boltReservedPrefix  = "__anno_"
+	boltAllBucket       = boltReservedPrefix + "all"
+	boltIDsBucket       = boltReservedPrefix + "ids"
+	boltDurationsBucket = boltReservedPrefix + "durations"
+	boltWordsBucket     = boltReservedPrefix + "words"
+	boltUntaggedBucket  = boltReservedPrefix + "untagged"
+	// boltMetaBucket held the longest duration before boltDurationsBucket, upgrade removes it
+	boltMetaBucket = boltReservedPrefix + "meta"
+)
+
 type BoltDBStorage struct {
-	seqVal int
-	fName  string
-	db     *bolt.DB
+	fName string
+	db    *bolt.DB
 }
 
 func NewBoltDBStorage(n string) (*BoltDBStorage, error) {
@@ -22,18 +48,195 @@ func NewBoltDBStorage(n string) (*BoltDBStorage, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &BoltDBStorage{db: db, fName: n}, err
+	s := &BoltDBStorage{db: db, fName: n}
+	if err := s.upgrade(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
 }
 
-func (s *BoltDBStorage) seq() int {
-	s.seqVal++
-	return s.seqVal
+func isTagBucket(name []byte) bool {
+	return !strings.HasPrefix(string(name), boltReservedPrefix)
+}
+
+// boltStep moves c to the previous key if desc is set, otherwise to the next one
+func boltStep(c *bolt.Cursor, desc bool) ([]byte, []byte) {
+	if desc {
+		return c.Prev()
+	}
+	return c.Next()
+}
+
+func boltKey(createdAt int, id string) []byte {
+	return []byte(fmt.Sprintf("%s-seq:%s", time.Unix(int64(createdAt), 0).Format(time.RFC3339), id))
+}
+
+// upgrade creates missing internal buckets, assigns IDs to annotations stored by older versions
+// and builds the word index for DBs created before full-text search.
+func (s *BoltDBStorage) upgrade() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(boltIDsBucket)) == nil {
+			if err := s.assignIDs(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket([]byte(boltWordsBucket)) == nil {
+			if err := s.indexWords(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket([]byte(boltDurationsBucket)) == nil {
+			if err := s.indexDurations(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket([]byte(boltUntaggedBucket)) == nil {
+			if err := s.indexUntagged(tx); err != nil {
+				return err
+			}
+		}
+		if tx.Bucket([]byte(boltMetaBucket)) != nil {
+			return tx.DeleteBucket([]byte(boltMetaBucket))
+		}
+		return nil
+	})
+}
+
+// assignIDs gives IDs to annotations that were stored by versions without them.
+// Those only live in their tag bucket, so each copy becomes its own annotation.
+func (s *BoltDBStorage) assignIDs(tx *bolt.Tx) error {
+	all, err := tx.CreateBucket([]byte(boltAllBucket))
+	if err != nil {
+		return err
+	}
+	ids, err := tx.CreateBucket([]byte(boltIDsBucket))
+	if err != nil {
+		return err
+	}
+
+	return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if !isTagBucket(name) {
+			return nil
+		}
+		var legacy []Annotation
+		var keys [][]byte
+		b.ForEach(func(k, v []byte) error {
+			var a Annotation
+			if err := json.Unmarshal(v, &a); err != nil {
+				return nil
+			}
+			a.Tags = []string{string(name)}
+			legacy = append(legacy, a)
+			keys = append(keys, append([]byte{}, k...))
+			return nil
+		})
+		// legacy keys use the same format, so remove all of them before writing new ones
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		for _, a := range legacy {
+			seq, _ := ids.NextSequence()
+			a.ID = strconv.FormatUint(seq, 10)
+			key := boltKey(a.CreatedAt, a.ID)
+			val, _ := json.Marshal(a)
+			if err := b.Put(key, val); err != nil {
+				return err
+			}
+			if err := all.Put(key, val); err != nil {
+				return err
+			}
+			if err := ids.Put([]byte(a.ID), key); err != nil {
+				return err
+			}
+		}
+		if len(legacy) > 0 {
+			log.Printf("Assigned IDs to %d annotations for tag %s", len(legacy), name)
+		}
+		return nil
+	})
+}
+
+// indexWords creates the word index for all existing annotations
+func (s *BoltDBStorage) indexWords(tx *bolt.Tx) error {
+	words, err := tx.CreateBucket([]byte(boltWordsBucket))
+	if err != nil {
+		return err
+	}
+	count := 0
+	err = tx.Bucket([]byte(boltAllBucket)).ForEach(func(k, v []byte) error {
+		var a Annotation
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+		count++
+		for _, w := range a.searchWords() {
+			if err := words.Put(boltWordKey(w, k), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if count > 0 {
+		log.Printf("Indexed words of %d annotations", count)
+	}
+	return err
+}
+
+func boltWordKey(word string, key []byte) []byte {
+	return append([]byte(word+"\x00"), key...)
+}
+
+// indexDurations creates the duration index for all existing annotations
+func (s *BoltDBStorage) indexDurations(tx *bolt.Tx) error {
+	durations, err := tx.CreateBucket([]byte(boltDurationsBucket))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(boltAllBucket)).ForEach(func(k, v []byte) error {
+		var a Annotation
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+		if d := a.End() - a.CreatedAt; d > 0 {
+			return durations.Put(boltDurationKey(d, k), nil)
+		}
+		return nil
+	})
+}
+
+// indexUntagged copies all existing annotations without tags to the untagged bucket
+func (s *BoltDBStorage) indexUntagged(tx *bolt.Tx) error {
+	untagged, err := tx.CreateBucket([]byte(boltUntaggedBucket))
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(boltAllBucket)).ForEach(func(k, v []byte) error {
+		var a Annotation
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+		if len(a.Tags) == 0 {
+			return untagged.Put(k, v)
+		}
+		return nil
+	})
+}
+
+// boltDurationKey sorts by duration, it's zero padded to the length of the largest int
+func boltDurationKey(d int, key []byte) []byte {
+	return append([]byte(fmt.Sprintf("%019d\x00", d)), key...)
 }
 
 func (s *BoltDBStorage) TagStats() (res TagStats, err error) {
 	res = make(map[string]int)
 	s.db.View(func(tx *bolt.Tx) error {
 		tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			if !isTagBucket(name) {
+				return nil
+			}
 			stats := b.Stats()
 			res[string(name)] += stats.KeyN
 			return nil
@@ -48,7 +251,9 @@ func (s *BoltDBStorage) AllTags() (res []string) {
 	res = []string{}
 	s.db.View(func(tx *bolt.Tx) error {
 		tx.ForEach(func(name []byte, b *bolt.Bucket) error {
-			res = append(res, string(name))
+			if isTagBucket(name) {
+				res = append(res, string(name))
+			}
 			return nil
 		})
 		return nil
@@ -57,25 +262,304 @@ func (s *BoltDBStorage) AllTags() (res []string) {
 	return res
 }
 
-func (s *BoltDBStorage) Add(a Annotation) error {
-	// make a copy of a and skip the tags, we don't need them in the DB
-	val, _ := json.Marshal(Annotation{CreatedAt: a.CreatedAt, Message: a.Message})
+func (s *BoltDBStorage) Add(a Annotation) (id string, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		seq, err := tx.Bucket([]byte(boltIDsBucket)).NextSequence()
+		if err != nil {
+			return err
+		}
+		a.ID = strconv.FormatUint(seq, 10)
+		return s.put(tx, a)
+	})
+	if err != nil {
+		return "", err
+	}
+	return a.ID, nil
+}
+
+// put writes a to the canonical, ID, word and tag buckets, a.ID has to be set
+func (s *BoltDBStorage) put(tx *bolt.Tx, a Annotation) error {
+	key := boltKey(a.CreatedAt, a.ID)
+	val, _ := json.Marshal(a)
+
+	for _, tag := range a.Tags {
+		if !isTagBucket([]byte(tag)) {
+			return fmt.Errorf("invalid tag \"%s\", prefix %s is reserved", tag, boltReservedPrefix)
+		}
+		b, err := tx.CreateBucketIfNotExists([]byte(tag))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		if err = b.Put(key, val); err != nil {
+			return fmt.Errorf("err adding to bucket: %s", err)
+		}
+	}
+	if len(a.Tags) == 0 {
+		if err := tx.Bucket([]byte(boltUntaggedBucket)).Put(key, val); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket([]byte(boltAllBucket)).Put(key, val); err != nil {
+		return err
+	}
+	for _, w := range a.searchWords() {
+		if err := tx.Bucket([]byte(boltWordsBucket)).Put(boltWordKey(w, key), nil); err != nil {
+			return err
+		}
+	}
+	if d := a.End() - a.CreatedAt; d > 0 {
+		if err := tx.Bucket([]byte(boltDurationsBucket)).Put(boltDurationKey(d, key), nil); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket([]byte(boltIDsBucket)).Put([]byte(a.ID), key)
+}
+
+// maxDuration returns the longest duration of any stored annotation
+func (s *BoltDBStorage) maxDuration(tx *bolt.Tx) int {
+	k, _ := tx.Bucket([]byte(boltDurationsBucket)).Cursor().Last()
+	if k == nil {
+		return 0
+	}
+	d, _ := strconv.Atoi(string(k[:bytes.IndexByte(k, 0)]))
+	return d
+}
+
+// get returns the canonical copy of annotation id
+func (s *BoltDBStorage) get(tx *bolt.Tx, id string) (a Annotation, err error) {
+	key := tx.Bucket([]byte(boltIDsBucket)).Get([]byte(id))
+	if key == nil {
+		return a, ErrNotFound
+	}
+	err = json.Unmarshal(tx.Bucket([]byte(boltAllBucket)).Get(key), &a)
+	return a, err
+}
+
+// remove deletes a from the canonical, ID, word and tag buckets
+func (s *BoltDBStorage) remove(tx *bolt.Tx, a Annotation) error {
+	key := boltKey(a.CreatedAt, a.ID)
+	for _, tag := range a.Tags {
+		b := tx.Bucket([]byte(tag))
+		if b == nil {
+			continue
+		}
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+		if k, _ := b.Cursor().First(); k == nil {
+			if err := tx.DeleteBucket([]byte(tag)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(a.Tags) == 0 {
+		if err := tx.Bucket([]byte(boltUntaggedBucket)).Delete(key); err != nil {
+			return err
+		}
+	}
+	if err := tx.Bucket([]byte(boltAllBucket)).Delete(key); err != nil {
+		return err
+	}
+	for _, w := range a.searchWords() {
+		if err := tx.Bucket([]byte(boltWordsBucket)).Delete(boltWordKey(w, key)); err != nil {
+			return err
+		}
+	}
+	if d := a.End() - a.CreatedAt; d > 0 {
+		if err := tx.Bucket([]byte(boltDurationsBucket)).Delete(boltDurationKey(d, key)); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket([]byte(boltIDsBucket)).Delete([]byte(a.ID))
+}
+
+func (s *BoltDBStorage) Get(id string) (a Annotation, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		a, err = s.get(tx, id)
+		return err
+	})
+	return a, err
+}
+
+func (s *BoltDBStorage) Delete(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		a, err := s.get(tx, id)
+		if err != nil {
+			return err
+		}
+		return s.remove(tx, a)
+	})
+}
+
+// Update rewrites all copies of the annotation, tag buckets are added and removed as needed
+func (s *BoltDBStorage) Update(id string, p AnnotationPatch) (a Annotation, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if a, err = s.get(tx, id); err != nil {
+			return err
+		}
+		if err = s.remove(tx, a); err != nil {
+			return err
+		}
+		p.Apply(&a)
+		return s.put(tx, a)
+	})
+	return a, err
+}
+
+// listBucket returns the canonical copies of all annotations in a tag bucket
+func (s *BoltDBStorage) listBucket(tx *bolt.Tx, tag string) (res []Annotation, err error) {
+	b := tx.Bucket([]byte(tag))
+	if b == nil || !isTagBucket([]byte(tag)) {
+		return nil, ErrTagNotFound
+	}
+	err = b.ForEach(func(k, v []byte) error {
+		var a Annotation
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+		c, err := s.get(tx, a.ID)
+		res = append(res, c)
+		return err
+	})
+	return res, err
+}
+
+func (s *BoltDBStorage) RenameTag(from, to string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		list, err := s.listBucket(tx, from)
+		if err != nil {
+			return err
+		}
+		for _, a := range list {
+			if err := s.remove(tx, a); err != nil {
+				return err
+			}
+			a.Tags = renameTag(a.Tags, from, to)
+			if err := s.put(tx, a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *BoltDBStorage) DeleteTag(tag string) (count int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		list, err := s.listBucket(tx, tag)
+		if err != nil {
+			return err
+		}
+		for _, a := range list {
+			if err := s.remove(tx, a); err != nil {
+				return err
+			}
+		}
+		count = len(list)
+		return nil
+	})
+	return
+}
 
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		for _, tag := range a.Tags {
-			b, err := tx.CreateBucketIfNotExists([]byte(tag))
+func (s *BoltDBStorage) Expire(tag string, before int) (count int, err error) {
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		name := []byte(tag)
+		if tag == "" {
+			name = []byte(boltUntaggedBucket)
+		} else if !isTagBucket(name) {
+			return nil
+		}
+		b := tx.Bucket(name)
+		if b == nil {
+			return nil
+		}
+
+		end := []byte(time.Unix(int64(before), 0).Format(time.RFC3339))
+		var list []Annotation
+		c := b.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k[:len(end)], end) < 0; k, v = c.Next() {
+			var a Annotation
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			list = append(list, a)
+		}
+
+		for _, a := range list {
+			a, err := s.get(tx, a.ID)
 			if err != nil {
-				return fmt.Errorf("create bucket: %s", err)
+				return err
 			}
-			key := fmt.Sprintf("%s-seq:%d", time.Unix(int64(a.CreatedAt), 0).Format(time.RFC3339), s.seq())
-			if err = b.Put([]byte(key), val); err != nil {
-				return fmt.Errorf("err adding to bucket: %s", err)
+			if err := s.remove(tx, a); err != nil {
+				return err
 			}
 		}
+		count = len(list)
 		return nil
 	})
+	return
+}
 
-	return err
+func (s *BoltDBStorage) Walk(fn func(a Annotation) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(boltAllBucket)).ForEach(func(k, v []byte) error {
+			var a Annotation
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			return fn(a)
+		})
+	})
+}
+
+// Backup writes a consistent copy of the whole DB file to w
+func (s *BoltDBStorage) Backup(w io.Writer) (n int64, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return
+}
+
+// RestoreBoltDB replaces the DB file with a snapshot created by Backup, it must not be open
+func RestoreBoltDB(snapshot, dbFile string) error {
+	// make sure the snapshot is a usable DB before overwriting anything
+	db, err := bolt.Open(snapshot, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return fmt.Errorf("can't open snapshot %s: %s", snapshot, err)
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(boltIDsBucket)) == nil || tx.Bucket([]byte(boltAllBucket)) == nil {
+			return fmt.Errorf("%s is not an annotations DB", snapshot)
+		}
+		return nil
+	})
+	db.Close()
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// write to a temp file first so a failed copy doesn't destroy the existing DB
+	tmp := dbFile + ".restore"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dbFile)
 }
 
 func (s *BoltDBStorage) GetCount(tag string) (count int) {
@@ -90,27 +574,160 @@ func (s *BoltDBStorage) GetCount(tag string) (count int) {
 	return
 }
 
-func (s *BoltDBStorage) ListForTag(tag string, r, until int, out *[]Annotation) (err error) {
-	err = s.db.View(func(tx *bolt.Tx) error {
+// ListForTag walks the tag bucket in either direction, keys sort like positions
+func (s *BoltDBStorage) ListForTag(tag string, r, until int, opts ListOpts, out *[]Annotation) (err error) {
+	return s.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(tag))
 		if b == nil {
 			return nil
 		}
+		return s.listRange(tx, b, r, until, opts, func(a Annotation) Annotation { return a.forTag(tag) }, out)
+	})
+}
+
+func (s *BoltDBStorage) List(r, until int, opts ListOpts, out *[]Annotation) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return s.listRange(tx, tx.Bucket([]byte(boltAllBucket)), r, until, opts, Annotation.inMillis, out)
+	})
+}
+
+// listRange appends the annotations of bucket b overlapping [until-r, until] as returned by conv
+func (s *BoltDBStorage) listRange(tx *bolt.Tx, b *bolt.Bucket, r, until int, opts ListOpts, conv func(Annotation) Annotation, out *[]Annotation) error {
+	start := []byte(time.Unix(int64(until-r-s.maxDuration(tx)), 0).Format(time.RFC3339))
+	end := []byte(time.Unix(int64(until), 0).Format(time.RFC3339))
+	var after []byte
+	if opts.After != nil {
+		after = boltKey(opts.After.CreatedAt, opts.After.ID)
+	}
+
+	c := b.Cursor()
+	var k, v []byte
+	if opts.Desc {
+		// position on the last key before the cursor or the end of the range
+		from := []byte(time.Unix(int64(until+1), 0).Format(time.RFC3339))
+		if after != nil && bytes.Compare(after, from) < 0 {
+			from = after
+		}
+		if k, _ = c.Seek(from); k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+	} else {
+		from := start
+		if after != nil && bytes.Compare(after, from) > 0 {
+			from = after
+		}
+		if k, v = c.Seek(from); k != nil && bytes.Equal(k, after) {
+			k, v = c.Next()
+		}
+	}
+
+	count := 0
+	for ; k != nil && (opts.Limit == 0 || count < opts.Limit); k, v = boltStep(c, opts.Desc) {
+		if bytes.Compare(k[:len(end)], end) > 0 || bytes.Compare(k[:len(start)], start) < 0 {
+			break
+		}
+		var a Annotation
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+		if a.Overlaps(until-r, until) {
+			*out = append(*out, conv(a))
+			count++
+		}
+	}
+	return nil
+}
+
+// ListForTags counts how many of the tag buckets hold each annotation in the range,
+// the result is read from the canonical bucket to get all tags
+func (s *BoltDBStorage) ListForTags(tags []string, op TagOp, r, until int, out *[]Annotation) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		start := []byte(time.Unix(int64(until-r-s.maxDuration(tx)), 0).Format(time.RFC3339))
+		end := []byte(time.Unix(int64(until), 0).Format(time.RFC3339))
+
+		counts := make(map[string]int)
+		var keys []string
+		for _, tag := range tags {
+			b := tx.Bucket([]byte(tag))
+			if b == nil || !isTagBucket([]byte(tag)) {
+				continue
+			}
+			c := b.Cursor()
+			for k, _ := c.Seek(start); k != nil && bytes.Compare(k[:len(end)], end) <= 0; k, _ = c.Next() {
+				if counts[string(k)] == 0 {
+					keys = append(keys, string(k))
+				}
+				counts[string(k)]++
+			}
+		}
+		sort.Strings(keys)
 
-		start := []byte(time.Unix(int64(until-r), 0).Format(time.RFC3339))
+		all := tx.Bucket([]byte(boltAllBucket))
+		for _, k := range keys {
+			if op == TagAnd && counts[k] < len(tags) {
+				continue
+			}
+			var a Annotation
+			if err := json.Unmarshal(all.Get([]byte(k)), &a); err != nil {
+				return err
+			}
+			if a.Overlaps(until-r, until) {
+				*out = append(*out, a.inMillis())
+			}
+		}
+		return nil
+	})
+}
+
+// ListForMatchers scans the canonical bucket, labels aren't indexed
+func (s *BoltDBStorage) ListForMatchers(ms []*LabelMatcher, r, until int, out *[]Annotation) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		start := []byte(time.Unix(int64(until-r-s.maxDuration(tx)), 0).Format(time.RFC3339))
 		end := []byte(time.Unix(int64(until), 0).Format(time.RFC3339))
 
-		c := b.Cursor()
+		c := tx.Bucket([]byte(boltAllBucket)).Cursor()
 		for k, v := c.Seek(start); k != nil && bytes.Compare(k[:len(end)], end) <= 0; k, v = c.Next() {
 			var a Annotation
 			if err := json.Unmarshal(v, &a); err != nil {
 				return err
 			}
-			*out = append(*out, Annotation{CreatedAt: a.CreatedAt * 1000, Message: a.Message, Tags: []string{tag}})
+			if a.Overlaps(until-r, until) && a.MatchesLabels(ms) {
+				*out = append(*out, a.inMillis())
+			}
+		}
+		return nil
+	})
+}
+
+// Search walks the word index of the first term, the other terms are checked on the annotations
+func (s *BoltDBStorage) Search(terms []string, r, until int, out *[]Annotation) error {
+	if len(terms) == 0 {
+		return nil
+	}
+	return s.db.View(func(tx *bolt.Tx) error {
+		prefix := boltWordKey(terms[0], nil)
+		start := []byte(time.Unix(int64(until-r-s.maxDuration(tx)), 0).Format(time.RFC3339))
+		end := []byte(time.Unix(int64(until), 0).Format(time.RFC3339))
+
+		all := tx.Bucket([]byte(boltAllBucket))
+		c := tx.Bucket([]byte(boltWordsBucket)).Cursor()
+		for k, _ := c.Seek(append(prefix, start...)); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			key := k[len(prefix):]
+			if bytes.Compare(key[:len(end)], end) > 0 {
+				break
+			}
+			var a Annotation
+			if err := json.Unmarshal(all.Get(key), &a); err != nil {
+				return err
+			}
+			if a.Overlaps(until-r, until) && a.MatchesTerms(terms) {
+				*out = append(*out, a.inMillis())
+			}
 		}
 		return nil
 	})
-	return
 }
 
 func (s *BoltDBStorage) Close() {
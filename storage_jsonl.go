@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strconv"
+)
+
+/*
+	JSONLStorage appends every change as one JSON line to a log file and keeps
+	an in-memory index that is rebuilt from the file on startup.
+	a line holds the full annotation, a later line for the same id replaces it
+	and deletions are written as {"id":"<id>","deleted":true}.
+	every change is written to the file before the index is changed, so a failed write changes nothing.
+	Compact rewrites the file from the index without superseded and deleted records, it runs on startup,
+	on SIGHUP and from the admin endpoint. for logrotate, rotate the file without copytruncate and send
+	SIGHUP in postrotate: the server then writes a new file with all current annotations, the rotated
+	files keep the history of changes.
+*/
+
+type JSONLStorage struct {
+	*MemoryStorage
+	fName string
+	f     *os.File
+}
+
+type jsonlRecord struct {
+	Annotation
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+func NewJSONLStorage(n string) (*JSONLStorage, error) {
+	s := &JSONLStorage{MemoryStorage: NewMemoryStorage(), fName: n}
+	records, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if records > len(s.byID) {
+		if err := s.compact(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	f, err := os.OpenFile(n, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s.f = f
+	return s, nil
+}
+
+// load replays the log file into the in-memory index and returns the number of records
+func (s *JSONLStorage) load() (int, error) {
+	f, err := os.Open(s.fName)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	count := 0
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var rec jsonlRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil || rec.ID == "" {
+			log.Printf("skipping invalid line %d in %s: %s", line, s.fName, scanner.Bytes())
+			continue
+		}
+
+		if a, ok := s.byID[rec.ID]; ok {
+			s.remove(a)
+		}
+		if !rec.Deleted {
+			s.put(rec.Annotation)
+		}
+		if seq, err := strconv.Atoi(rec.ID); err == nil && seq > s.seq {
+			s.seq = seq
+		}
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, err
+	}
+
+	log.Printf("Loaded %d records from %s", count, s.fName)
+	return count, nil
+}
+
+// Compact replaces the file with one holding only the current annotations
+func (s *JSONLStorage) Compact() error {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.compact()
+}
+
+// compact writes the index to a new file that is renamed over the old one and reopened,
+// a file that was rotated away is simply written anew. callers must hold the write lock
+func (s *JSONLStorage) compact() error {
+	tmp := s.fName + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, id := range s.all {
+		if err := enc.Encode(jsonlRecord{Annotation: s.byID[id]}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	// IDs must not be reused, so the deletion of the last one is kept
+	if last := strconv.Itoa(s.seq); s.seq > 0 && s.byID[last].ID == "" {
+		if err := enc.Encode(jsonlRecord{Annotation: Annotation{ID: last}, Deleted: true}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.fName); err != nil {
+		return err
+	}
+
+	f, err = os.OpenFile(s.fName, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if s.f != nil {
+		s.f.Close()
+	}
+	s.f = f
+	log.Printf("Compacted %s to %d annotations", s.fName, len(s.all))
+	return nil
+}
+
+func (s *JSONLStorage) write(recs ...jsonlRecord) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	_, err := s.f.Write(buf.Bytes())
+	if err != nil {
+		log.Printf("writing to %s failed, err: %s", s.fName, err)
+	}
+	return err
+}
+
+func (s *JSONLStorage) writeAll(list []Annotation, deleted bool) error {
+	recs := make([]jsonlRecord, 0, len(list))
+	for _, a := range list {
+		if deleted {
+			a = Annotation{ID: a.ID}
+		}
+		recs = append(recs, jsonlRecord{Annotation: a, Deleted: deleted})
+	}
+	return s.write(recs...)
+}
+
+func (s *JSONLStorage) Add(a Annotation) (id string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	a.ID = strconv.Itoa(s.seq + 1)
+	if err := s.write(jsonlRecord{Annotation: a}); err != nil {
+		return "", err
+	}
+	s.seq++
+	s.put(a)
+	return a.ID, nil
+}
+
+func (s *JSONLStorage) Delete(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	a, ok := s.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := s.writeAll([]Annotation{a}, true); err != nil {
+		return err
+	}
+	s.remove(a)
+	return nil
+}
+
+func (s *JSONLStorage) Update(id string, p AnnotationPatch) (Annotation, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	a, ok := s.byID[id]
+	if !ok {
+		return a, ErrNotFound
+	}
+	updated := a
+	p.Apply(&updated)
+	if err := s.write(jsonlRecord{Annotation: updated}); err != nil {
+		return a, err
+	}
+	s.remove(a)
+	s.put(updated)
+	return s.byID[id], nil
+}
+
+func (s *JSONLStorage) RenameTag(from, to string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	changed, err := s.renameTag(from, to)
+	if err != nil {
+		return err
+	}
+	if err := s.writeAll(changed, false); err != nil {
+		return err
+	}
+	s.replace(changed)
+	return nil
+}
+
+func (s *JSONLStorage) DeleteTag(tag string) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	deleted, err := s.tagged(tag)
+	if err != nil {
+		return 0, err
+	}
+	if err := s.writeAll(deleted, true); err != nil {
+		return 0, err
+	}
+	s.removeAll(deleted)
+	return len(deleted), nil
+}
+
+func (s *JSONLStorage) Expire(tag string, before int) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	expired := s.expired(tag, before)
+	if len(expired) == 0 {
+		return 0, nil
+	}
+	if err := s.writeAll(expired, true); err != nil {
+		return 0, err
+	}
+	s.removeAll(expired)
+	return len(expired), nil
+}
+
+func (s *JSONLStorage) Close() {
+	s.f.Close()
+	log.Printf("Closed JSON-lines storage")
+}
+
+func (s *JSONLStorage) Cleanup() {
+	s.Close()
+	os.Remove(s.fName)
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+/*
+	MemoryStorage keeps all annotations in memory, nothing survives a restart.
+	all holds the IDs of all annotations sorted by creation time and tags holds
+	the same for every tag so range queries can use a binary search, untagged for annotations without tags.
+	range queries start maxDuration earlier to find annotations that started before the range,
+	durations counts the annotations per duration so maxDuration shrinks when the longest ones are removed.
+*/
+
+type MemoryStorage struct {
+	sync.RWMutex
+	seq         int
+	byID        map[string]Annotation
+	all         []string
+	tags        map[string][]string
+	untagged    []string
+	durations   map[int]int
+	maxDuration int
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		byID:      make(map[string]Annotation),
+		tags:      make(map[string][]string),
+		durations: make(map[int]int),
+	}
+}
+
+// before reports whether the annotation with ID a sorts before the one with ID b
+func (s *MemoryStorage) before(a, b string) bool {
+	x, y := s.byID[a], s.byID[b]
+	if x.CreatedAt != y.CreatedAt {
+		return x.CreatedAt < y.CreatedAt
+	}
+	return x.ID < y.ID
+}
+
+func (s *MemoryStorage) insert(list []string, id string) []string {
+	i := sort.Search(len(list), func(i int) bool { return !s.before(list[i], id) })
+	list = append(list, "")
+	copy(list[i+1:], list[i:])
+	list[i] = id
+	return list
+}
+
+func (s *MemoryStorage) removeID(list []string, id string) []string {
+	i := sort.Search(len(list), func(i int) bool { return !s.before(list[i], id) })
+	if i < len(list) && list[i] == id {
+		list = append(list[:i], list[i+1:]...)
+	}
+	return list
+}
+
+// first returns the index of the first annotation in list created at or after ts
+func (s *MemoryStorage) first(list []string, ts int) int {
+	return sort.Search(len(list), func(i int) bool { return s.byID[list[i]].CreatedAt >= ts })
+}
+
+// put indexes a, a.ID has to be set. callers must hold the write lock
+func (s *MemoryStorage) put(a Annotation) {
+	// a tag given twice would be indexed twice
+	a.Tags = uniqueTags(a.Tags)
+	a.Labels = copyLabels(a.Labels)
+	s.byID[a.ID] = a
+	s.all = s.insert(s.all, a.ID)
+	for _, tag := range a.Tags {
+		s.tags[tag] = s.insert(s.tags[tag], a.ID)
+	}
+	if len(a.Tags) == 0 {
+		s.untagged = s.insert(s.untagged, a.ID)
+	}
+	if d := a.End() - a.CreatedAt; d > 0 {
+		s.durations[d]++
+		if d > s.maxDuration {
+			s.maxDuration = d
+		}
+	}
+}
+
+// remove drops a from all indexes. callers must hold the write lock
+func (s *MemoryStorage) remove(a Annotation) {
+	for _, tag := range a.Tags {
+		s.tags[tag] = s.removeID(s.tags[tag], a.ID)
+		if len(s.tags[tag]) == 0 {
+			delete(s.tags, tag)
+		}
+	}
+	if len(a.Tags) == 0 {
+		s.untagged = s.removeID(s.untagged, a.ID)
+	}
+	s.all = s.removeID(s.all, a.ID)
+	delete(s.byID, a.ID)
+
+	d := a.End() - a.CreatedAt
+	if d <= 0 {
+		return
+	}
+	if s.durations[d]--; s.durations[d] > 0 {
+		return
+	}
+	delete(s.durations, d)
+	if d == s.maxDuration {
+		s.maxDuration = 0
+		for d := range s.durations {
+			if d > s.maxDuration {
+				s.maxDuration = d
+			}
+		}
+	}
+}
+
+func (s *MemoryStorage) Add(a Annotation) (id string, err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.seq++
+	a.ID = strconv.Itoa(s.seq)
+	s.put(a)
+	return a.ID, nil
+}
+
+func (s *MemoryStorage) Get(id string) (Annotation, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	a, ok := s.byID[id]
+	if !ok {
+		return a, ErrNotFound
+	}
+	return a, nil
+}
+
+func (s *MemoryStorage) Delete(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	a, ok := s.byID[id]
+	if !ok {
+		return ErrNotFound
+	}
+	s.remove(a)
+	return nil
+}
+
+func (s *MemoryStorage) Update(id string, p AnnotationPatch) (Annotation, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	a, ok := s.byID[id]
+	if !ok {
+		return a, ErrNotFound
+	}
+	s.remove(a)
+	p.Apply(&a)
+	s.put(a)
+	return s.byID[id], nil
+}
+
+func (s *MemoryStorage) ListForTag(tag string, r, until int, opts ListOpts, out *[]Annotation) (err error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	s.listRange(s.tags[tag], r, until, opts, func(a Annotation) Annotation { return a.forTag(tag) }, out)
+	return nil
+}
+
+func (s *MemoryStorage) List(r, until int, opts ListOpts, out *[]Annotation) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	s.listRange(s.all, r, until, opts, Annotation.inMillis, out)
+	return nil
+}
+
+// listRange appends the annotations of list overlapping [until-r, until] as returned by conv
+func (s *MemoryStorage) listRange(list []string, r, until int, opts ListOpts, conv func(Annotation) Annotation, out *[]Annotation) {
+	from, to := s.first(list, until-r-s.maxDuration), s.first(list, until+1)
+	if c := opts.After; c != nil && opts.Desc {
+		// list is sorted by position as well
+		if i := sort.Search(len(list), func(i int) bool { return !s.byID[list[i]].position().Less(*c) }); i < to {
+			to = i
+		}
+	} else if c != nil {
+		if i := sort.Search(len(list), func(i int) bool { return c.Less(s.byID[list[i]].position()) }); i > from {
+			from = i
+		}
+	}
+
+	count := 0
+	for n := 0; n < to-from && (opts.Limit == 0 || count < opts.Limit); n++ {
+		i := from + n
+		if opts.Desc {
+			i = to - 1 - n
+		}
+		a := s.byID[list[i]]
+		if a.Overlaps(until-r, until) {
+			*out = append(*out, conv(a))
+			count++
+		}
+	}
+}
+
+// ListForTags counts how many of the tags each annotation in the range has
+func (s *MemoryStorage) ListForTags(tags []string, op TagOp, r, until int, out *[]Annotation) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	counts := make(map[string]int)
+	var ids []string
+	for _, tag := range tags {
+		list := s.tags[tag]
+		for i := s.first(list, until-r-s.maxDuration); i < len(list); i++ {
+			a := s.byID[list[i]]
+			if a.CreatedAt > until {
+				break
+			}
+			if !a.Overlaps(until-r, until) {
+				continue
+			}
+			if counts[a.ID] == 0 {
+				ids = append(ids, a.ID)
+			}
+			counts[a.ID]++
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return s.before(ids[i], ids[j]) })
+	for _, id := range ids {
+		if op == TagOr || counts[id] == len(tags) {
+			*out = append(*out, s.byID[id].inMillis())
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) ListForMatchers(ms []*LabelMatcher, r, until int, out *[]Annotation) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	for i := s.first(s.all, until-r-s.maxDuration); i < len(s.all); i++ {
+		a := s.byID[s.all[i]]
+		if a.CreatedAt > until {
+			break
+		}
+		if a.Overlaps(until-r, until) && a.MatchesLabels(ms) {
+			*out = append(*out, a.inMillis())
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) Search(terms []string, r, until int, out *[]Annotation) error {
+	s.RLock()
+	defer s.RUnlock()
+
+	for i := s.first(s.all, until-r-s.maxDuration); i < len(s.all); i++ {
+		a := s.byID[s.all[i]]
+		if a.CreatedAt > until {
+			break
+		}
+		if a.Overlaps(until-r, until) && a.MatchesTerms(terms) {
+			*out = append(*out, a.inMillis())
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) TagStats() (TagStats, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	res := make(TagStats)
+	for tag, list := range s.tags {
+		res[tag] = len(list)
+	}
+	return res, nil
+}
+
+func (s *MemoryStorage) AllTags() []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	res := make([]string, 0, len(s.tags))
+	for tag := range s.tags {
+		res = append(res, tag)
+	}
+	return res
+}
+
+// annotations returns copies of the annotations for the IDs in list
+func (s *MemoryStorage) annotations(list []string) []Annotation {
+	res := make([]Annotation, 0, len(list))
+	for _, id := range list {
+		res = append(res, s.byID[id])
+	}
+	return res
+}
+
+func (s *MemoryStorage) RenameTag(from, to string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	changed, err := s.renameTag(from, to)
+	if err != nil {
+		return err
+	}
+	s.replace(changed)
+	return nil
+}
+
+// renameTag returns the annotations with tag "from" renamed, the index isn't changed yet. callers must hold the lock
+func (s *MemoryStorage) renameTag(from, to string) ([]Annotation, error) {
+	list, ok := s.tags[from]
+	if !ok {
+		return nil, ErrTagNotFound
+	}
+	changed := s.annotations(list)
+	for i, a := range changed {
+		changed[i].Tags = renameTag(a.Tags, from, to)
+	}
+	return changed, nil
+}
+
+// replace indexes the changed annotations instead of the stored ones. callers must hold the write lock
+func (s *MemoryStorage) replace(changed []Annotation) {
+	for _, a := range changed {
+		s.remove(s.byID[a.ID])
+		s.put(a)
+	}
+}
+
+func (s *MemoryStorage) DeleteTag(tag string) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	deleted, err := s.tagged(tag)
+	if err != nil {
+		return 0, err
+	}
+	s.removeAll(deleted)
+	return len(deleted), nil
+}
+
+// tagged returns the annotations with tag. callers must hold the lock
+func (s *MemoryStorage) tagged(tag string) ([]Annotation, error) {
+	list, ok := s.tags[tag]
+	if !ok {
+		return nil, ErrTagNotFound
+	}
+	return s.annotations(list), nil
+}
+
+func (s *MemoryStorage) Expire(tag string, before int) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	expired := s.expired(tag, before)
+	s.removeAll(expired)
+	return len(expired), nil
+}
+
+// expired returns the annotations with tag created before "before", the untagged ones for "". callers must hold the lock
+func (s *MemoryStorage) expired(tag string, before int) []Annotation {
+	list := s.tags[tag]
+	if tag == "" {
+		list = s.untagged
+	}
+	return s.annotations(list[:s.first(list, before)])
+}
+
+// removeAll drops the annotations from all indexes. callers must hold the write lock
+func (s *MemoryStorage) removeAll(list []Annotation) {
+	for _, a := range list {
+		s.remove(a)
+	}
+}
+
+func (s *MemoryStorage) Walk(fn func(a Annotation) error) error {
+	s.RLock()
+	list := s.annotations(s.all)
+	s.RUnlock()
+
+	for _, a := range list {
+		if err := fn(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStorage) GetCount(tag string) int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return len(s.tags[tag])
+}
+
+func (s *MemoryStorage) Close() {
+	log.Printf("Closed memory storage")
+}
+
+func (s *MemoryStorage) Cleanup() {
+	s.Close()
+}
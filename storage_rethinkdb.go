@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"regexp"
 	"strings"
 	"time"
 
@@ -33,6 +34,10 @@ func NewRethinkDBStorage(conn string) (*RethinkDBStorage, error) {
 	r.DbCreate(db).Run(s)
 	r.Db(db).TableCreate("annotations").Run(s)
 	r.Db(db).Table("annotations").IndexCreate("created_at").Run(s)
+	// range queries look up the longest duration to know how much earlier than the range they have to start
+	r.Db(db).Table("annotations").IndexCreateFunc("duration", func(row r.Term) interface{} {
+		return row.Field("ends_at").Default(0).Sub(row.Field("created_at"))
+	}).Run(s)
 
 	s.Use(db)
 
@@ -65,6 +70,21 @@ func (s *RethinkDBStorage) AllTags() (res []string) {
 	return res
 }
 
+// TagsMatching lets rethinkdb find the tags, its regular expressions use the same RE2 syntax as Go
+func (s *RethinkDBStorage) TagsMatching(re *regexp.Regexp) (res []string, err error) {
+	q, err := r.Table("annotations").ConcatMap(func(row r.Term) r.Term {
+		return row.Field("tags")
+	}).Distinct().Filter(func(tag r.Term) r.Term {
+		return tag.Match(re.String())
+	}).Run(s.session)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+	err = q.All(&res)
+	return res, err
+}
+
 func (s *RethinkDBStorage) TagStats() (TagStats, error) {
 	var res TagStats = make(map[string]int)
 
@@ -95,22 +115,144 @@ func (s *RethinkDBStorage) TagStats() (TagStats, error) {
 	return res, nil
 }
 
-func (s *RethinkDBStorage) Add(a Annotation) error {
-	_, err := r.Table("annotations").Insert(a).RunWrite(s.session)
+func (s *RethinkDBStorage) Add(a Annotation) (id string, err error) {
+	// IDs are always assigned by RethinkDB
+	a.ID = ""
+	res, err := r.Table("annotations").Insert(a).RunWrite(s.session)
 	if err != nil {
 		log.Printf("Saving annotation failed, err: %s", err)
+		return "", err
 	}
-	return err
+	if len(res.GeneratedKeys) != 1 {
+		return "", fmt.Errorf("expected one generated key, got %d", len(res.GeneratedKeys))
+	}
+	return res.GeneratedKeys[0], nil
+}
+
+func (s *RethinkDBStorage) Delete(id string) error {
+	res, err := r.Table("annotations").Get(id).Delete().RunWrite(s.session)
+	if err != nil {
+		log.Printf("Deleting annotation %s failed, err: %s", id, err)
+		return err
+	}
+	if res.Deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// rethinkPatch returns the update for p, labels have to be replaced instead of merged into the existing ones
+func rethinkPatch(p AnnotationPatch) interface{} {
+	if p.Labels == nil {
+		return p
+	}
+	labels := *p.Labels
+	p.Labels = nil
+	return r.Expr(p).Merge(map[string]interface{}{"labels": r.Literal(labels)})
+}
+
+func (s *RethinkDBStorage) Get(id string) (a Annotation, err error) {
+	q, err := r.Table("annotations").Get(id).Run(s.session)
+	if err != nil {
+		return a, err
+	}
+	defer q.Close()
+	if q.IsNil() {
+		return a, ErrNotFound
+	}
+	err = q.One(&a)
+	return a, err
 }
 
-func (s *RethinkDBStorage) ListForTag(tag string, ra, until int, out *[]Annotation) (err error) {
-	start := float64(until-ra) - 0.5
-	end := float64(until) + 0.5
+func (s *RethinkDBStorage) Update(id string, p AnnotationPatch) (a Annotation, err error) {
+	res, err := r.Table("annotations").Get(id).Update(rethinkPatch(p)).RunWrite(s.session)
+	if err != nil {
+		log.Printf("Updating annotation %s failed, err: %s", id, err)
+		return a, err
+	}
+	if res.Skipped > 0 {
+		return a, ErrNotFound
+	}
+
+	q, err := r.Table("annotations").Get(id).Run(s.session)
+	if err != nil {
+		return a, err
+	}
+	defer q.Close()
+	err = q.One(&a)
+	return a, err
+}
 
-	res, err := r.Table("annotations").Between(start, end, r.BetweenOpts{Index: "created_at", RightBound: "open", LeftBound: "open"}).Filter(func(row r.Term) r.Term {
+func (s *RethinkDBStorage) withTag(tag string) r.Term {
+	return r.Table("annotations").Filter(func(row r.Term) r.Term {
 		return row.Field("tags").Contains(tag)
-	}).Run(s.session)
+	})
+}
+
+func (s *RethinkDBStorage) RenameTag(from, to string) error {
+	res, err := s.withTag(from).Update(func(row r.Term) r.Term {
+		return r.Expr(map[string]interface{}{"tags": row.Field("tags").SetDifference([]string{from}).SetInsert(to)})
+	}).RunWrite(s.session)
+	if err != nil {
+		log.Printf("Renaming tag %s to %s failed, err: %s", from, to, err)
+		return err
+	}
+	if res.Replaced == 0 {
+		return ErrTagNotFound
+	}
+	return nil
+}
+
+func (s *RethinkDBStorage) DeleteTag(tag string) (int, error) {
+	res, err := s.withTag(tag).Delete().RunWrite(s.session)
+	if err != nil {
+		log.Printf("Deleting tag %s failed, err: %s", tag, err)
+		return 0, err
+	}
+	if res.Deleted == 0 {
+		return 0, ErrTagNotFound
+	}
+	return res.Deleted, nil
+}
+
+func (s *RethinkDBStorage) Expire(tag string, before int) (int, error) {
+	res, err := r.Table("annotations").Between(r.MinVal, before, r.BetweenOpts{Index: "created_at"}).Filter(func(row r.Term) r.Term {
+		if tag == "" {
+			return row.Field("tags").Default([]string{}).IsEmpty()
+		}
+		return row.Field("tags").Contains(tag)
+	}).Delete().RunWrite(s.session)
+	if err != nil {
+		log.Printf("Expiring annotations for tag %s failed, err: %s", tag, err)
+		return 0, err
+	}
+	return res.Deleted, nil
+}
+
+func (s *RethinkDBStorage) Walk(fn func(a Annotation) error) error {
+	res, err := r.Table("annotations").OrderBy(r.OrderByOpts{Index: "created_at"}).Run(s.session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	var a Annotation
+	for res.Next(&a) {
+		if err := fn(a); err != nil {
+			return err
+		}
+		a = Annotation{}
+	}
+	return res.Err()
+}
 
+// ListForTag reads the created_at index in the requested order, ties are ordered by ID by rethinkdb
+func (s *RethinkDBStorage) ListForTag(tag string, ra, until int, opts ListOpts, out *[]Annotation) (err error) {
+	q, err := s.rangeQuery(func(row r.Term) r.Term { return row.Field("tags").Contains(tag) }, ra, until, opts)
+	if err != nil {
+		return err
+	}
+	res, err := q.Run(s.session)
 	if err != nil {
 		log.Printf("err geting annotations for tag %s err: %s", tag, err)
 		return err
@@ -119,11 +261,225 @@ func (s *RethinkDBStorage) ListForTag(tag string, ra, until int, out *[]Annotati
 
 	var a Annotation
 	for res.Next(&a) {
-		*out = append(*out, Annotation{CreatedAt: a.CreatedAt * 1000, Message: a.Message, Tags: []string{tag}})
+		*out = append(*out, a.forTag(tag))
+		a = Annotation{}
 	}
 	return err
 }
 
+func (s *RethinkDBStorage) List(ra, until int, opts ListOpts, out *[]Annotation) error {
+	q, err := s.rangeQuery(nil, ra, until, opts)
+	if err != nil {
+		return err
+	}
+	res, err := q.Run(s.session)
+	if err != nil {
+		log.Printf("err geting annotations err: %s", err)
+		return err
+	}
+	defer res.Close()
+
+	var a Annotation
+	for res.Next(&a) {
+		*out = append(*out, a.inMillis())
+		a = Annotation{}
+	}
+	return res.Err()
+}
+
+// maxDuration reads the longest duration of any annotation from the duration index
+func (s *RethinkDBStorage) maxDuration() (int, error) {
+	res, err := r.Table("annotations").OrderBy(r.OrderByOpts{Index: r.Desc("duration")}).Limit(1).Run(s.session)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Close()
+
+	var a Annotation
+	if res.Next(&a) {
+		return a.End() - a.CreatedAt, nil
+	}
+	return 0, res.Err()
+}
+
+// rethinkBounds returns the part of the created_at index holding the annotations overlapping [until-ra, until]
+// that follow the cursor of opts, annotations can start up to maxDuration before the range
+func rethinkBounds(ra, until, maxDuration int, opts ListOpts) (from, end interface{}) {
+	from, end = until-ra-maxDuration, float64(until)+0.5
+	if c := opts.After; c != nil && opts.Desc && c.CreatedAt < until {
+		end = float64(c.CreatedAt) + 0.5
+	} else if c != nil && !opts.Desc && c.CreatedAt > until-ra-maxDuration {
+		from = c.CreatedAt
+	}
+	return from, end
+}
+
+// between returns the annotations in the part of the created_at index that can overlap [until-ra, until]
+func (s *RethinkDBStorage) between(ra, until int, opts ListOpts) (r.Term, error) {
+	d, err := s.maxDuration()
+	if err != nil {
+		return r.Term{}, err
+	}
+	from, end := rethinkBounds(ra, until, d, opts)
+	return r.Table("annotations").Between(from, end, r.BetweenOpts{Index: "created_at", RightBound: "open"}), nil
+}
+
+// rangeQuery queries the annotations overlapping [until-ra, until] that pass filter, if set, ordered and paged by opts
+func (s *RethinkDBStorage) rangeQuery(filter func(row r.Term) r.Term, ra, until int, opts ListOpts) (r.Term, error) {
+	start := until - ra
+	q, err := s.between(ra, until, opts)
+	if err != nil {
+		return q, err
+	}
+	index := r.Asc("created_at")
+	if opts.Desc {
+		index = r.Desc("created_at")
+	}
+
+	// annotations with an end time can overlap the range even if they were created before it
+	q = q.OrderBy(r.OrderByOpts{Index: index}).Filter(func(row r.Term) r.Term {
+		cond := row.Field("created_at").Ge(start).Or(row.Field("ends_at").Default(0).Ge(start))
+		if filter != nil {
+			cond = filter(row).And(cond)
+		}
+		if c := opts.After; c != nil && opts.Desc {
+			cond = cond.And(row.Field("created_at").Lt(c.CreatedAt).Or(row.Field("id").Lt(c.ID)))
+		} else if c != nil {
+			cond = cond.And(row.Field("created_at").Gt(c.CreatedAt).Or(row.Field("id").Gt(c.ID)))
+		}
+		return cond
+	})
+	if opts.Limit > 0 {
+		q = q.Limit(opts.Limit)
+	}
+	return q, nil
+}
+
+func (s *RethinkDBStorage) ListForTags(tags []string, op TagOp, ra, until int, out *[]Annotation) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	start := until - ra
+	q, err := s.between(ra, until, ListOpts{})
+	if err != nil {
+		return err
+	}
+
+	res, err := q.Filter(func(row r.Term) r.Term {
+		var hasTags r.Term
+		for i, tag := range tags {
+			switch {
+			case i == 0:
+				hasTags = row.Field("tags").Contains(tag)
+			case op == TagAnd:
+				hasTags = hasTags.And(row.Field("tags").Contains(tag))
+			default:
+				hasTags = hasTags.Or(row.Field("tags").Contains(tag))
+			}
+		}
+		return hasTags.And(row.Field("created_at").Ge(start).Or(row.Field("ends_at").Default(0).Ge(start)))
+	}).OrderBy("created_at").Run(s.session)
+	if err != nil {
+		log.Printf("err geting annotations for tags %v err: %s", tags, err)
+		return err
+	}
+	defer res.Close()
+
+	var a Annotation
+	for res.Next(&a) {
+		*out = append(*out, a.inMillis())
+		a = Annotation{}
+	}
+	return res.Err()
+}
+
+// ListForMatchers lets rethinkdb filter by the equality matchers, the others are checked afterwards
+func (s *RethinkDBStorage) ListForMatchers(ms []*LabelMatcher, ra, until int, out *[]Annotation) error {
+	start := until - ra
+	q, err := s.between(ra, until, ListOpts{})
+	if err != nil {
+		return err
+	}
+
+	res, err := q.Filter(func(row r.Term) r.Term {
+		cond := row.Field("created_at").Ge(start).Or(row.Field("ends_at").Default(0).Ge(start))
+		for _, m := range ms {
+			if m.Type == MatchEqual && m.Value != "" {
+				cond = cond.And(row.Field("labels").Field(m.Name).Default("").Eq(m.Value))
+			}
+		}
+		return cond
+	}).OrderBy("created_at").Run(s.session)
+	if err != nil {
+		log.Printf("err geting annotations for matchers %v err: %s", ms, err)
+		return err
+	}
+	defer res.Close()
+
+	var a Annotation
+	for res.Next(&a) {
+		if a.MatchesLabels(ms) {
+			*out = append(*out, a.inMillis())
+		}
+		a = Annotation{}
+	}
+	return res.Err()
+}
+
+// Search lets rethinkdb prefilter on the whole document, whole words are checked afterwards
+func (s *RethinkDBStorage) Search(terms []string, ra, until int, out *[]Annotation) error {
+	start := until - ra
+	q, err := s.between(ra, until, ListOpts{})
+	if err != nil {
+		return err
+	}
+
+	res, err := q.Filter(func(row r.Term) r.Term {
+		cond := row.Field("created_at").Ge(start).Or(row.Field("ends_at").Default(0).Ge(start))
+		for _, term := range terms {
+			cond = cond.And(row.CoerceTo("string").Match("(?i)" + regexp.QuoteMeta(term)))
+		}
+		return cond
+	}).OrderBy("created_at").Run(s.session)
+	if err != nil {
+		log.Printf("err searching annotations for %v err: %s", terms, err)
+		return err
+	}
+	defer res.Close()
+
+	var a Annotation
+	for res.Next(&a) {
+		if a.MatchesTerms(terms) {
+			*out = append(*out, a.inMillis())
+		}
+		a = Annotation{}
+	}
+	return res.Err()
+}
+
+// WatchAdded follows a changefeed on the annotations table, so annotations added through
+// other servers are reported as well
+func (s *RethinkDBStorage) WatchAdded(fn func(a Annotation)) error {
+	res, err := r.Table("annotations").Changes().Run(s.session)
+	if err != nil {
+		return err
+	}
+	defer res.Close()
+
+	var change struct {
+		NewVal *Annotation `gorethink:"new_val"`
+		OldVal *Annotation `gorethink:"old_val"`
+	}
+	for res.Next(&change) {
+		// updates and deletes have an old value
+		if change.NewVal != nil && change.OldVal == nil {
+			fn(change.NewVal.inMillis())
+		}
+		change.NewVal, change.OldVal = nil, nil
+	}
+	return res.Err()
+}
+
 func (s *RethinkDBStorage) Close() {
 	s.session.Close()
 	log.Printf("Closed RethinkDB storage")
@@ -137,6 +493,6 @@ func (s *RethinkDBStorage) Cleanup() {
 func (s *RethinkDBStorage) GetCount(tag string) (count int) {
 	var temp []Annotation
 	ts := int(time.Now().Unix())
-	s.ListForTag(tag, ts, ts, &temp)
+	s.ListForTag(tag, ts, ts, ListOpts{}, &temp)
 	return len(temp)
 }
@@ -24,7 +24,7 @@ func TestRethinkAnnotationAdd(t *testing.T) {
 	a := Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag1", "tag2"}}
 
 	count := s.GetCount("tag1")
-	err = s.Add(a)
+	_, err = s.Add(a)
 	if err != nil {
 		t.Errorf("no good: %s", err)
 		return
@@ -154,3 +154,500 @@ func TestRethinkTagStats(t *testing.T) {
 		return
 	}
 }
+
+func TestRethinkDelete(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	id, err := s.Add(Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag1", "tag2"}})
+	if err != nil || id == "" {
+		t.Errorf("no good, id: %s err: %s", id, err)
+		return
+	}
+	s.Add(Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag2"}})
+
+	if err := s.Delete(id); err != nil {
+		t.Errorf("no good: %s", err)
+	}
+	if c := s.GetCount("tag1"); c != 0 {
+		t.Errorf("no good, wrong count %d", c)
+	}
+	if c := s.GetCount("tag2"); c != 1 {
+		t.Errorf("no good, wrong count %d", c)
+	}
+
+	if err := s.Delete(id); err != ErrNotFound {
+		t.Errorf("no good, expected ErrNotFound, got: %s", err)
+	}
+}
+
+func TestRethinkUpdate(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	id, _ := s.Add(Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag1", "tag2"}})
+
+	msg := "Fixed message"
+	tags := []string{"tag2", "tag3"}
+	a, err := s.Update(id, AnnotationPatch{Message: &msg, Tags: &tags})
+	if err != nil || a.ID != id || a.Message != msg || a.CreatedAt != ts || len(a.Tags) != 2 {
+		t.Errorf("no good, a: %#v err: %s", a, err)
+	}
+	if c := s.GetCount("tag1"); c != 0 {
+		t.Errorf("no good, wrong count %d", c)
+	}
+	if c := s.GetCount("tag3"); c != 1 {
+		t.Errorf("no good, wrong count %d", c)
+	}
+
+	createdAt := ts - 100
+	s.Update(id, AnnotationPatch{CreatedAt: &createdAt})
+	if a, err := s.Get(id); err != nil || a.CreatedAt != createdAt || a.Message != msg {
+		t.Errorf("no good, a: %#v err: %s", a, err)
+	}
+	if _, err := s.Get("12345"); err != ErrNotFound {
+		t.Errorf("no good, expected ErrNotFound, got: %s", err)
+	}
+	list, err := GetPosts(s, []string{"tag2"}, 10, ts)
+	if err != nil || len(list.Posts) != 0 {
+		t.Errorf("no good, wrong count, list: %#v, err: %s", list, err)
+	}
+	list, err = GetPosts(s, []string{"tag2"}, 200, ts)
+	if err != nil || len(list.Posts) != 1 || list.Posts[0].Message != msg {
+		t.Errorf("no good, wrong count, list: %#v, err: %s", list, err)
+	}
+
+	if _, err := s.Update("does-not-exist", AnnotationPatch{Message: &msg}); err != ErrNotFound {
+		t.Errorf("no good, expected ErrNotFound, got: %s", err)
+	}
+}
+
+func TestRethinkRenameTag(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	s.Add(Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag1", "tag2"}})
+	s.Add(Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag1"}})
+	s.Add(Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag3"}})
+
+	if err := s.RenameTag("tag1", "tag2"); err != nil {
+		t.Errorf("no good: %s", err)
+	}
+	if c := s.GetCount("tag1"); c != 0 {
+		t.Errorf("no good, wrong count %d", c)
+	}
+	if c := s.GetCount("tag2"); c != 2 {
+		t.Errorf("no good, wrong count %d", c)
+	}
+
+	list, err := GetPosts(s, []string{"tag2"}, 1000, ts)
+	if err != nil || len(list.Posts) != 2 {
+		t.Errorf("no good, wrong count, list: %#v, err: %s", list, err)
+	}
+
+	if err := s.RenameTag("tag1", "tag4"); err != ErrTagNotFound {
+		t.Errorf("no good, expected ErrTagNotFound, got: %s", err)
+	}
+}
+
+func TestRethinkDeleteTag(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	s.Add(Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag1", "tag2"}})
+	s.Add(Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag1"}})
+	s.Add(Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag2"}})
+
+	if count, err := s.DeleteTag("tag1"); err != nil || count != 2 {
+		t.Errorf("no good, count: %d err: %s", count, err)
+	}
+	if c := s.GetCount("tag2"); c != 1 {
+		t.Errorf("no good, wrong count %d", c)
+	}
+	if _, err := s.DeleteTag("tag1"); err != ErrTagNotFound {
+		t.Errorf("no good, expected ErrTagNotFound, got: %s", err)
+	}
+}
+
+func TestRethinkExpire(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	s.Add(Annotation{CreatedAt: ts - 100, Message: "Test message", Tags: []string{"tag1", "tag2"}})
+	s.Add(Annotation{CreatedAt: ts - 50, Message: "Test message", Tags: []string{"tag1"}})
+	s.Add(Annotation{CreatedAt: ts, Message: "Test message", Tags: []string{"tag1"}})
+
+	if count, err := s.Expire("tag1", ts-50); err != nil || count != 1 {
+		t.Errorf("no good, count: %d err: %s", count, err)
+	}
+	if c := s.GetCount("tag1"); c != 2 {
+		t.Errorf("no good, wrong count %d", c)
+	}
+	if c := s.GetCount("tag2"); c != 0 {
+		t.Errorf("no good, wrong count %d", c)
+	}
+
+	s.Add(Annotation{CreatedAt: ts - 100, Message: "Untagged message"})
+	s.Add(Annotation{CreatedAt: ts, Message: "Untagged message"})
+	if count, err := s.Expire("", ts-50); err != nil || count != 1 {
+		t.Errorf("no good, untagged count: %d err: %s", count, err)
+	}
+
+	if count, err := s.Expire("tag123", ts); err != nil || count != 0 {
+		t.Errorf("no good, count: %d err: %s", count, err)
+	}
+}
+
+func TestRethinkWalk(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	s.Add(Annotation{CreatedAt: ts, Message: "Test message 2", Tags: []string{"tag1", "tag2"}})
+	s.Add(Annotation{CreatedAt: ts - 10, Message: "Test message 1", Tags: []string{"tag2"}})
+	s.Add(Annotation{CreatedAt: ts + 10, Message: "Test message 3"})
+
+	var list []Annotation
+	err = s.Walk(func(a Annotation) error {
+		list = append(list, a)
+		return nil
+	})
+	if err != nil || len(list) != 3 {
+		t.Errorf("no good, wrong list: %#v, err: %s", list, err)
+		return
+	}
+	if list[0].CreatedAt != ts-10 || list[1].Message != "Test message 2" || len(list[1].Tags) != 2 || len(list[2].Tags) != 0 {
+		t.Errorf("no good, wrong list: %#v", list)
+	}
+}
+
+func TestRethinkRanges(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	s.Add(Annotation{CreatedAt: ts - 7200, EndsAt: ts - 60, Message: "maintenance", Tags: []string{"range"}})
+	s.Add(Annotation{CreatedAt: ts - 7200, Message: "point", Tags: []string{"range"}})
+	s.Add(Annotation{CreatedAt: ts - 30, Message: "recent", Tags: []string{"range"}})
+
+	// the maintenance window started before the last hour but still overlaps it
+	var list []Annotation
+	if err := s.ListForTag("range", 3600, ts, ListOpts{}, &list); err != nil || len(list) != 2 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+		return
+	}
+	for _, a := range list {
+		if a.Message == "maintenance" && (a.CreatedAt != (ts-7200)*1000 || a.EndsAt != (ts-60)*1000) {
+			t.Errorf("no good, wrong times: %#v", a)
+		}
+		if a.Message == "point" {
+			t.Errorf("no good, annotation outside of range: %#v", a)
+		}
+	}
+
+	list = nil
+	if err := s.ListForTag("range", 19, ts-40, ListOpts{}, &list); err != nil || len(list) != 0 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+	}
+}
+
+func TestRethinkMetadata(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	id, err := s.Add(Annotation{CreatedAt: ts, Message: "deployed", Tags: []string{"meta"}, Author: "jane", Source: "ci", URL: "http://ci/1", Severity: "info", Labels: map[string]string{"env": "prod", "team": "web"}})
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+
+	var list []Annotation
+	if err := s.ListForTag("meta", 10, ts, ListOpts{}, &list); err != nil || len(list) != 1 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+		return
+	}
+	a := list[0]
+	if a.Author != "jane" || a.Source != "ci" || a.URL != "http://ci/1" || a.Severity != "info" || a.Labels["env"] != "prod" || a.Labels["team"] != "web" {
+		t.Errorf("no good, wrong metadata: %#v", a)
+	}
+
+	// labels are replaced, not merged
+	severity := "critical"
+	labels := map[string]string{"env": "staging"}
+	a, err = s.Update(id, AnnotationPatch{Severity: &severity, Labels: &labels})
+	if err != nil || a.Severity != "critical" || a.Author != "jane" || len(a.Labels) != 1 || a.Labels["env"] != "staging" {
+		t.Errorf("no good, err: %s annotation: %#v", err, a)
+	}
+
+	err = s.Walk(func(a Annotation) error {
+		if a.Severity != "critical" || len(a.Labels) != 1 || a.Labels["env"] != "staging" {
+			t.Errorf("no good, wrong metadata: %#v", a)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("no good: %s", err)
+	}
+}
+
+func TestRethinkMatchers(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	s.Add(Annotation{CreatedAt: ts - 10, Message: "api prod", Tags: []string{"tag1", "tag2"}, Labels: map[string]string{"service": "api", "env": "prod"}})
+	s.Add(Annotation{CreatedAt: ts - 5, Message: "api dev", Tags: []string{"tag1"}, Labels: map[string]string{"service": "api", "env": "dev"}})
+	s.Add(Annotation{CreatedAt: ts - 5, Message: "web prod", Tags: []string{"tag1"}, Labels: map[string]string{"service": "web", "env": "prod"}})
+	s.Add(Annotation{CreatedAt: ts - 7200, Message: "api prod old", Tags: []string{"tag1"}, Labels: map[string]string{"service": "api", "env": "prod"}})
+
+	ms, _ := ParseMatchers(`{service="api",env=~"prod|staging"}`)
+	var list []Annotation
+	if err := s.ListForMatchers(ms, 3600, ts, &list); err != nil || len(list) != 1 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+		return
+	}
+	if a := list[0]; a.Message != "api prod" || a.CreatedAt != (ts-10)*1000 || len(a.Tags) != 2 {
+		t.Errorf("no good, wrong annotation: %#v", a)
+	}
+
+	list = nil
+	ms, _ = ParseMatchers(`{env!="dev",service=~"api|web"}`)
+	if err := s.ListForMatchers(ms, 3600, ts, &list); err != nil || len(list) != 2 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+	}
+}
+
+func TestRethinkSearch(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	s.Add(Annotation{CreatedAt: ts - 10, Message: "Payments migration started", Tags: []string{"tag1", "tag2"}})
+	s.Add(Annotation{CreatedAt: ts - 5, Message: "deploy", Author: "alice", Tags: []string{"tag1"}, Labels: map[string]string{"service": "payments"}})
+	s.Add(Annotation{CreatedAt: ts - 5, Message: "migrations for payment", Tags: []string{"tag1"}})
+	s.Add(Annotation{CreatedAt: ts - 7200, Message: "payments migration old", Tags: []string{"tag1"}})
+
+	var list []Annotation
+	if err := s.Search([]string{"payments", "migration"}, 3600, ts, &list); err != nil || len(list) != 1 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+		return
+	}
+	if a := list[0]; a.Message != "Payments migration started" || a.CreatedAt != (ts-10)*1000 || len(a.Tags) != 2 {
+		t.Errorf("no good, wrong annotation: %#v", a)
+	}
+
+	list = nil
+	if err := s.Search([]string{"payments"}, 3600, ts, &list); err != nil || len(list) != 2 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+	}
+
+	list = nil
+	if err := s.Search([]string{"alice", "deploy"}, 3600, ts, &list); err != nil || len(list) != 1 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+	}
+}
+
+func TestRethinkTagOps(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	s.Add(Annotation{CreatedAt: ts - 10, Message: "prod api", Tags: []string{"prod", "api", "deploy"}})
+	s.Add(Annotation{CreatedAt: ts - 5, Message: "prod web", Tags: []string{"prod", "web"}})
+	s.Add(Annotation{CreatedAt: ts - 5, Message: "dev api", Tags: []string{"api"}})
+	s.Add(Annotation{CreatedAt: ts - 7200, Message: "prod api old", Tags: []string{"prod", "api"}})
+
+	var list []Annotation
+	if err := s.ListForTags([]string{"prod", "api"}, TagAnd, 3600, ts, &list); err != nil || len(list) != 1 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+		return
+	}
+	if a := list[0]; a.Message != "prod api" || a.CreatedAt != (ts-10)*1000 || len(a.Tags) != 3 {
+		t.Errorf("no good, wrong annotation: %#v", a)
+	}
+
+	list = nil
+	if err := s.ListForTags([]string{"prod", "api"}, TagOr, 3600, ts, &list); err != nil || len(list) != 3 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+		return
+	}
+	if list[0].Message != "prod api" {
+		t.Errorf("no good, wrong order: %#v", list)
+	}
+
+	list = nil
+	if err := s.ListForTags([]string{"prod", "missing"}, TagAnd, 3600, ts, &list); err != nil || len(list) != 0 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+	}
+}
+
+func TestRethinkList(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	s.Add(Annotation{CreatedAt: ts - 20, Message: "tagged", Tags: []string{"b", "a"}})
+	s.Add(Annotation{CreatedAt: ts - 10, Message: "untagged"})
+	s.Add(Annotation{CreatedAt: ts - 5, Message: "latest", Tags: []string{"a"}})
+	s.Add(Annotation{CreatedAt: ts - 7200, Message: "old", Tags: []string{"a"}})
+
+	var list []Annotation
+	if err := s.List(3600, ts, ListOpts{}, &list); err != nil || len(list) != 3 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+		return
+	}
+	if a := list[0]; a.Message != "tagged" || a.CreatedAt != (ts-20)*1000 || len(a.Tags) != 2 || list[1].Message != "untagged" {
+		t.Errorf("no good, wrong annotations: %#v", list)
+	}
+
+	list = nil
+	if err := s.List(3600, ts, ListOpts{Desc: true, Limit: 2}, &list); err != nil || len(list) != 2 {
+		t.Errorf("no good, err: %s list: %#v", err, list)
+		return
+	}
+	if list[0].Message != "latest" || list[1].Message != "untagged" {
+		t.Errorf("no good, wrong order: %#v", list)
+	}
+}
+
+func TestRethinkRangeBounds(t *testing.T) {
+	ts := int(time.Now().Unix())
+
+	// a limited first page starts at the window, not at the oldest annotation
+	from, end := rethinkBounds(3600, ts, 60, ListOpts{Limit: 2})
+	if from != ts-3660 || end != float64(ts)+0.5 {
+		t.Errorf("no good, from: %v end: %v", from, end)
+	}
+
+	from, _ = rethinkBounds(3600, ts, 60, ListOpts{Limit: 2, After: &Cursor{CreatedAt: ts - 10, ID: "x"}})
+	if from != ts-10 {
+		t.Errorf("no good, from: %v", from)
+	}
+	from, end = rethinkBounds(3600, ts, 60, ListOpts{Limit: 2, Desc: true, After: &Cursor{CreatedAt: ts - 10, ID: "x"}})
+	if from != ts-3660 || end != float64(ts-10)+0.5 {
+		t.Errorf("no good, from: %v end: %v", from, end)
+	}
+}
+
+func TestRethinkPaging(t *testing.T) {
+	ts := int(time.Now().Unix())
+	dbName := fmt.Sprintf("anno%d", ts)
+	s, err := NewRethinkDBStorage("localhost:28015/" + dbName)
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	defer s.Cleanup()
+
+	s.Add(Annotation{CreatedAt: ts - 5000, EndsAt: ts - 20, Message: "long", Tags: []string{"page"}})
+	s.Add(Annotation{CreatedAt: ts - 40, Message: "msg1", Tags: []string{"page"}})
+	s.Add(Annotation{CreatedAt: ts - 30, Message: "msg2", Tags: []string{"page"}})
+	s.Add(Annotation{CreatedAt: ts - 30, Message: "msg3", Tags: []string{"page"}})
+	s.Add(Annotation{CreatedAt: ts - 10, Message: "msg4", Tags: []string{"page"}})
+	s.Add(Annotation{CreatedAt: ts - 7200, Message: "old", Tags: []string{"page"}})
+
+	var all []Annotation
+	if err := s.ListForTag("page", 3600, ts, ListOpts{}, &all); err != nil || len(all) != 5 {
+		t.Errorf("no good, err: %s list: %#v", err, all)
+		return
+	}
+
+	// paging in either direction returns every annotation once
+	for _, desc := range []bool{false, true} {
+		var pages []Annotation
+		opts := ListOpts{Limit: 2, Desc: desc}
+		for i := 0; i < 5; i++ {
+			var list []Annotation
+			if err := s.ListForTag("page", 3600, ts, opts, &list); err != nil || len(list) > 2 {
+				t.Errorf("no good, err: %s list: %#v", err, list)
+				return
+			}
+			if len(list) == 0 {
+				break
+			}
+			pages = append(pages, list...)
+			last := list[len(list)-1]
+			opts.After = &Cursor{CreatedAt: last.CreatedAt / 1000, ID: last.ID}
+		}
+		if len(pages) != len(all) {
+			t.Errorf("no good, desc %t pages: %#v", desc, pages)
+			continue
+		}
+		for i := range all {
+			j := i
+			if desc {
+				j = len(all) - 1 - i
+			}
+			if pages[j].ID != all[i].ID {
+				t.Errorf("no good, desc %t wrong order: %#v", desc, pages)
+				break
+			}
+		}
+	}
+}
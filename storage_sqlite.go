@@ -0,0 +1,599 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS annotations (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		created_at INTEGER NOT NULL,
+		ends_at    INTEGER NOT NULL DEFAULT 0,
+		message    TEXT    NOT NULL,
+		author     TEXT    NOT NULL DEFAULT '',
+		source     TEXT    NOT NULL DEFAULT '',
+		url        TEXT    NOT NULL DEFAULT '',
+		severity   TEXT    NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS annotations_created_at ON annotations (created_at)`,
+	`CREATE TABLE IF NOT EXISTS annotation_tags (
+		annotation_id INTEGER NOT NULL REFERENCES annotations (id) ON DELETE CASCADE,
+		tag           TEXT    NOT NULL,
+		PRIMARY KEY (annotation_id, tag)
+	)`,
+	`CREATE INDEX IF NOT EXISTS annotation_tags_tag ON annotation_tags (tag, annotation_id)`,
+	`CREATE TABLE IF NOT EXISTS annotation_labels (
+		annotation_id INTEGER NOT NULL REFERENCES annotations (id) ON DELETE CASCADE,
+		name          TEXT    NOT NULL,
+		value         TEXT    NOT NULL,
+		PRIMARY KEY (annotation_id, name)
+	)`,
+}
+
+// sqliteColumns are columns added after the first release, DBs created before get them on open
+var sqliteColumns = []struct{ table, column, definition string }{
+	{"annotations", "ends_at", "INTEGER NOT NULL DEFAULT 0"},
+	{"annotations", "author", "TEXT NOT NULL DEFAULT ''"},
+	{"annotations", "source", "TEXT NOT NULL DEFAULT ''"},
+	{"annotations", "url", "TEXT NOT NULL DEFAULT ''"},
+	{"annotations", "severity", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// sqliteAnnotationColumns are the columns read by scanAnnotation, labels are collected into a JSON object
+const sqliteAnnotationColumns = `a.id, a.created_at, a.ends_at, a.message, a.author, a.source, a.url, a.severity,
+		(SELECT json_group_object(l.name, l.value) FROM annotation_labels l WHERE l.annotation_id = a.id)`
+
+type sqliteRow interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAnnotation reads the sqliteAnnotationColumns of row followed by extra
+func scanAnnotation(row sqliteRow, extra ...interface{}) (a Annotation, err error) {
+	var labels string
+	dest := append([]interface{}{&a.ID, &a.CreatedAt, &a.EndsAt, &a.Message, &a.Author, &a.Source, &a.URL, &a.Severity, &labels}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return a, err
+	}
+	if err := json.Unmarshal([]byte(labels), &a.Labels); err != nil {
+		return a, err
+	}
+	a.Labels = copyLabels(a.Labels)
+	return a, nil
+}
+
+type SQLiteStorage struct {
+	fName string
+	db    *sql.DB
+}
+
+func NewSQLiteStorage(n string) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite3", "file:"+n+"?_foreign_keys=1")
+	if err != nil {
+		return nil, err
+	}
+	// sqlite only allows one writer at a time anyway
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range sqliteSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	for _, c := range sqliteColumns {
+		if err := addSQLiteColumn(db, c.table, c.column, c.definition); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &SQLiteStorage{db: db, fName: n}, nil
+}
+
+func addSQLiteColumn(db *sql.DB, table, column, definition string) error {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition))
+	return err
+}
+
+func (s *SQLiteStorage) insertTags(tx *sql.Tx, id int64, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO annotation_tags (annotation_id, tag) VALUES (?, ?)`, id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) insertLabels(tx *sql.Tx, id int64, labels map[string]string) error {
+	for name, value := range labels {
+		if _, err := tx.Exec(`INSERT INTO annotation_labels (annotation_id, name, value) VALUES (?, ?, ?)`, id, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) Add(a Annotation) (id string, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO annotations (created_at, ends_at, message, author, source, url, severity) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		a.CreatedAt, a.EndsAt, a.Message, a.Author, a.Source, a.URL, a.Severity)
+	if err != nil {
+		log.Printf("Saving annotation failed, err: %s", err)
+		return "", err
+	}
+	rowID, err := res.LastInsertId()
+	if err != nil {
+		return "", err
+	}
+	if err := s.insertTags(tx, rowID, a.Tags); err != nil {
+		return "", err
+	}
+	if err := s.insertLabels(tx, rowID, a.Labels); err != nil {
+		return "", err
+	}
+	return strconv.FormatInt(rowID, 10), tx.Commit()
+}
+
+func (s *SQLiteStorage) Delete(id string) error {
+	res, err := s.db.Exec(`DELETE FROM annotations WHERE id = ?`, id)
+	if err != nil {
+		log.Printf("Deleting annotation %s failed, err: %s", id, err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// get loads annotation id including all of its tags
+func (s *SQLiteStorage) get(tx *sql.Tx, id string) (a Annotation, err error) {
+	a, err = scanAnnotation(tx.QueryRow(`SELECT `+sqliteAnnotationColumns+` FROM annotations a WHERE a.id = ?`, id))
+	if err == sql.ErrNoRows {
+		return a, ErrNotFound
+	}
+	if err != nil {
+		return a, err
+	}
+
+	rows, err := tx.Query(`SELECT tag FROM annotation_tags WHERE annotation_id = ? ORDER BY tag`, a.ID)
+	if err != nil {
+		return a, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return a, err
+		}
+		a.Tags = append(a.Tags, tag)
+	}
+	return a, rows.Err()
+}
+
+func (s *SQLiteStorage) Get(id string) (a Annotation, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback()
+	return s.get(tx, id)
+}
+
+func (s *SQLiteStorage) Update(id string, p AnnotationPatch) (a Annotation, err error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback()
+
+	if a, err = s.get(tx, id); err != nil {
+		return a, err
+	}
+	p.Apply(&a)
+
+	if _, err := tx.Exec(`
+		UPDATE annotations SET created_at = ?, ends_at = ?, message = ?, author = ?, source = ?, url = ?, severity = ? WHERE id = ?`,
+		a.CreatedAt, a.EndsAt, a.Message, a.Author, a.Source, a.URL, a.Severity, a.ID); err != nil {
+		log.Printf("Updating annotation %s failed, err: %s", id, err)
+		return a, err
+	}
+	if _, err := tx.Exec(`DELETE FROM annotation_tags WHERE annotation_id = ?`, a.ID); err != nil {
+		return a, err
+	}
+	if _, err := tx.Exec(`DELETE FROM annotation_labels WHERE annotation_id = ?`, a.ID); err != nil {
+		return a, err
+	}
+	rowID, _ := strconv.ParseInt(a.ID, 10, 64)
+	if err := s.insertTags(tx, rowID, a.Tags); err != nil {
+		return a, err
+	}
+	if err := s.insertLabels(tx, rowID, a.Labels); err != nil {
+		return a, err
+	}
+	return a, tx.Commit()
+}
+
+// ListForTag orders by the text of the ID to page like the other storages
+func (s *SQLiteStorage) ListForTag(tag string, r, until int, opts ListOpts, out *[]Annotation) (err error) {
+	query, args := sqlitePage(`
+		SELECT `+sqliteAnnotationColumns+`
+		FROM annotations a JOIN annotation_tags t ON t.annotation_id = a.id
+		WHERE t.tag = ? AND a.created_at <= ? AND MAX(a.created_at, a.ends_at) >= ?`, []interface{}{tag, until, until - r}, opts)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("err geting annotations for tag %s err: %s", tag, err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAnnotation(rows)
+		if err != nil {
+			return err
+		}
+		*out = append(*out, a.forTag(tag))
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteStorage) List(r, until int, opts ListOpts, out *[]Annotation) error {
+	query, args := sqlitePage(`
+		SELECT `+sqliteAnnotationColumns+`,
+			(SELECT json_group_array(t.tag) FROM annotation_tags t WHERE t.annotation_id = a.id)
+		FROM annotations a
+		WHERE a.created_at <= ? AND MAX(a.created_at, a.ends_at) >= ?`, []interface{}{until, until - r}, opts)
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		log.Printf("err geting annotations err: %s", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tags string
+		a, err := scanAnnotation(rows, &tags)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(tags), &a.Tags); err != nil {
+			return err
+		}
+		sort.Strings(a.Tags)
+		*out = append(*out, a.inMillis())
+	}
+	return rows.Err()
+}
+
+// sqlitePage adds the cursor, order and limit of opts to a query on annotations a
+func sqlitePage(query string, args []interface{}, opts ListOpts) (string, []interface{}) {
+	order, cmp := "ASC", ">"
+	if opts.Desc {
+		order, cmp = "DESC", "<"
+	}
+	if c := opts.After; c != nil {
+		query += ` AND (a.created_at ` + cmp + ` ? OR a.created_at = ? AND CAST(a.id AS TEXT) ` + cmp + ` ?)`
+		args = append(args, c.CreatedAt, c.CreatedAt, c.ID)
+	}
+	// a negative limit is no limit for sqlite
+	limit := -1
+	if opts.Limit > 0 {
+		limit = opts.Limit
+	}
+	return query + `
+		ORDER BY a.created_at ` + order + `, CAST(a.id AS TEXT) ` + order + ` LIMIT ?`, append(args, limit)
+}
+
+// ListForTags lets sqlite count how many of the tags each annotation has
+func (s *SQLiteStorage) ListForTags(tags []string, op TagOp, r, until int, out *[]Annotation) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	args := []interface{}{until, until - r}
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	min := 1
+	if op == TagAnd {
+		min = len(tags)
+	}
+	rows, err := s.db.Query(`
+		SELECT `+sqliteAnnotationColumns+`,
+			(SELECT json_group_array(t.tag) FROM annotation_tags t WHERE t.annotation_id = a.id)
+		FROM annotations a
+		WHERE a.created_at <= ? AND MAX(a.created_at, a.ends_at) >= ? AND a.id IN (
+			SELECT annotation_id FROM annotation_tags WHERE tag IN (?`+strings.Repeat(", ?", len(tags)-1)+`)
+			GROUP BY annotation_id HAVING COUNT(*) >= ?)
+		ORDER BY a.created_at, a.id`, append(args, min)...)
+	if err != nil {
+		log.Printf("err geting annotations for tags %v err: %s", tags, err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var allTags string
+		a, err := scanAnnotation(rows, &allTags)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal([]byte(allTags), &a.Tags); err != nil {
+			return err
+		}
+		sort.Strings(a.Tags)
+		*out = append(*out, a.inMillis())
+	}
+	return rows.Err()
+}
+
+// ListForMatchers lets sqlite filter by the equality matchers, the others are checked afterwards
+func (s *SQLiteStorage) ListForMatchers(ms []*LabelMatcher, r, until int, out *[]Annotation) error {
+	query := `
+		SELECT ` + sqliteAnnotationColumns + `,
+			(SELECT json_group_array(t.tag) FROM annotation_tags t WHERE t.annotation_id = a.id)
+		FROM annotations a
+		WHERE a.created_at <= ? AND MAX(a.created_at, a.ends_at) >= ?`
+	args := []interface{}{until, until - r}
+	for _, m := range ms {
+		if m.Type == MatchEqual && m.Value != "" {
+			query += ` AND EXISTS (SELECT 1 FROM annotation_labels m WHERE m.annotation_id = a.id AND m.name = ? AND m.value = ?)`
+			args = append(args, m.Name, m.Value)
+		}
+	}
+	rows, err := s.db.Query(query+` ORDER BY a.created_at, a.id`, args...)
+	if err != nil {
+		log.Printf("err geting annotations for matchers %v err: %s", ms, err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tags string
+		a, err := scanAnnotation(rows, &tags)
+		if err != nil {
+			return err
+		}
+		if !a.MatchesLabels(ms) {
+			continue
+		}
+		if err := json.Unmarshal([]byte(tags), &a.Tags); err != nil {
+			return err
+		}
+		sort.Strings(a.Tags)
+		*out = append(*out, a.inMillis())
+	}
+	return rows.Err()
+}
+
+// Search lets sqlite prefilter with LIKE, which only folds ASCII case, so whole words
+// and non-ASCII terms are checked afterwards
+func (s *SQLiteStorage) Search(terms []string, r, until int, out *[]Annotation) error {
+	query := `
+		SELECT ` + sqliteAnnotationColumns + `,
+			(SELECT json_group_array(t.tag) FROM annotation_tags t WHERE t.annotation_id = a.id)
+		FROM annotations a
+		WHERE a.created_at <= ? AND MAX(a.created_at, a.ends_at) >= ?`
+	args := []interface{}{until, until - r}
+	for _, term := range terms {
+		if strings.IndexFunc(term, func(c rune) bool { return c > unicode.MaxASCII }) >= 0 {
+			continue
+		}
+		// terms only hold letters and digits, so they need no escaping
+		query += ` AND (a.message || ' ' || a.author || ' ' || a.source || ' ' || a.severity || ' ' ||
+			COALESCE((SELECT group_concat(w.value, ' ') FROM annotation_labels w WHERE w.annotation_id = a.id), '')) LIKE ?`
+		args = append(args, "%"+term+"%")
+	}
+	rows, err := s.db.Query(query+` ORDER BY a.created_at, a.id`, args...)
+	if err != nil {
+		log.Printf("err searching annotations for %v err: %s", terms, err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tags string
+		a, err := scanAnnotation(rows, &tags)
+		if err != nil {
+			return err
+		}
+		if !a.MatchesTerms(terms) {
+			continue
+		}
+		if err := json.Unmarshal([]byte(tags), &a.Tags); err != nil {
+			return err
+		}
+		sort.Strings(a.Tags)
+		*out = append(*out, a.inMillis())
+	}
+	return rows.Err()
+}
+
+func (s *SQLiteStorage) TagStats() (TagStats, error) {
+	var res TagStats = make(map[string]int)
+
+	rows, err := s.db.Query(`SELECT tag, COUNT(*) FROM annotation_tags GROUP BY tag`)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag string
+		var count int
+		if err := rows.Scan(&tag, &count); err != nil {
+			return res, err
+		}
+		res[tag] = count
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLiteStorage) AllTags() (res []string) {
+	res = []string{}
+	rows, err := s.db.Query(`SELECT DISTINCT tag FROM annotation_tags`)
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tag string
+		if rows.Scan(&tag) == nil {
+			res = append(res, tag)
+		}
+	}
+	return res
+}
+
+func (s *SQLiteStorage) RenameTag(from, to string) error {
+	if from == to {
+		if s.GetCount(from) == 0 {
+			return ErrTagNotFound
+		}
+		return nil
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT OR IGNORE INTO annotation_tags (annotation_id, tag) SELECT annotation_id, ? FROM annotation_tags WHERE tag = ?`, to, from); err != nil {
+		log.Printf("Renaming tag %s to %s failed, err: %s", from, to, err)
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM annotation_tags WHERE tag = ?`, from)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrTagNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStorage) DeleteTag(tag string) (int, error) {
+	res, err := s.db.Exec(`DELETE FROM annotations WHERE id IN (SELECT annotation_id FROM annotation_tags WHERE tag = ?)`, tag)
+	if err != nil {
+		log.Printf("Deleting tag %s failed, err: %s", tag, err)
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return 0, ErrTagNotFound
+	}
+	return int(n), nil
+}
+
+func (s *SQLiteStorage) Expire(tag string, before int) (int, error) {
+	query, args := `DELETE FROM annotations WHERE created_at < ? AND id IN (SELECT annotation_id FROM annotation_tags WHERE tag = ?)`, []interface{}{before, tag}
+	if tag == "" {
+		// the primary key of annotation_tags starts with annotation_id, so this is a lookup per annotation
+		query, args = `DELETE FROM annotations WHERE created_at < ? AND NOT EXISTS (SELECT 1 FROM annotation_tags t WHERE t.annotation_id = annotations.id)`, []interface{}{before}
+	}
+	res, err := s.db.Exec(query, args...)
+	if err != nil {
+		log.Printf("Expiring annotations for tag %s failed, err: %s", tag, err)
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// sqliteWalkBatch is how many annotations Walk reads before calling fn for them
+const sqliteWalkBatch = 500
+
+// Walk reads the annotations in batches and calls fn only after closing the rows,
+// so a slow fn doesn't hold the only connection
+func (s *SQLiteStorage) Walk(fn func(a Annotation) error) error {
+	var last *Annotation
+	for {
+		batch, err := s.walkBatch(last)
+		if err != nil {
+			return err
+		}
+		for _, a := range batch {
+			if err := fn(a); err != nil {
+				return err
+			}
+		}
+		if len(batch) < sqliteWalkBatch {
+			return nil
+		}
+		last = &batch[len(batch)-1]
+	}
+}
+
+// walkBatch returns the next sqliteWalkBatch annotations after last, ordered by creation time and ID
+func (s *SQLiteStorage) walkBatch(last *Annotation) (res []Annotation, err error) {
+	query := `
+		SELECT ` + sqliteAnnotationColumns + `,
+			(SELECT json_group_array(t.tag) FROM annotation_tags t WHERE t.annotation_id = a.id)
+		FROM annotations a`
+	var args []interface{}
+	if last != nil {
+		id, _ := strconv.ParseInt(last.ID, 10, 64)
+		query += ` WHERE a.created_at > ? OR a.created_at = ? AND a.id > ?`
+		args = append(args, last.CreatedAt, last.CreatedAt, id)
+	}
+	rows, err := s.db.Query(query+` ORDER BY a.created_at, a.id LIMIT ?`, append(args, sqliteWalkBatch)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tags string
+		a, err := scanAnnotation(rows, &tags)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(tags), &a.Tags); err != nil {
+			return nil, err
+		}
+		if len(a.Tags) == 0 {
+			a.Tags = nil
+		}
+		sort.Strings(a.Tags)
+		res = append(res, a)
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLiteStorage) GetCount(tag string) (count int) {
+	s.db.QueryRow(`SELECT COUNT(*) FROM annotation_tags WHERE tag = ?`, tag).Scan(&count)
+	return
+}
+
+func (s *SQLiteStorage) Close() {
+	s.db.Close()
+	log.Printf("Closed SQLite storage")
+}
+
+func (s *SQLiteStorage) Cleanup() {
+	s.Close()
+	os.Remove(s.fName)
+}
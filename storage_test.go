@@ -12,7 +12,7 @@ import (
 func TestStorageConfig(t *testing.T) {
 
 	// first, invalid ones
-	invalid := []string{"INVALID", "INVALID:1234", "local:/proc/123.db", "", "rethinkdb:localhost:28015"}
+	invalid := []string{"INVALID", "INVALID:1234", "local:/proc/123.db", "sqlite:/proc/123.db", "jsonl:/proc/123.jsonl", "", "memory", "rethinkdb:localhost:28015"}
 	for _, opt := range invalid {
 		s, err := NewStorage(opt)
 		if err == nil {
@@ -22,7 +22,7 @@ func TestStorageConfig(t *testing.T) {
 	}
 
 	// now the valid ones
-	valid := []string{"local:/tmp/123.db", "local:./test-123.db", "rethinkdb:localhost:28015/annotations"}
+	valid := []string{"local:/tmp/123.db", "local:./test-123.db", "sqlite:./test-123.sqlite", "jsonl:./test-123.jsonl", "memory:", "rethinkdb:localhost:28015/annotations"}
 	for _, opt := range valid {
 		s, err := NewStorage(opt)
 		if err != nil {
@@ -32,3 +32,15 @@ func TestStorageConfig(t *testing.T) {
 		}
 	}
 }
+
+func TestParseTagOp(t *testing.T) {
+	if op, err := ParseTagOp("AND"); err != nil || op != TagAnd {
+		t.Errorf("no good, err: %s op: %d", err, op)
+	}
+	if op, err := ParseTagOp("or"); err != nil || op != TagOr {
+		t.Errorf("no good, err: %s op: %d", err, op)
+	}
+	if _, err := ParseTagOp("xor"); err == nil {
+		t.Errorf("no good, expected error for xor")
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+)
+
+/*
+	new annotations can be followed live as server-sent events:
+		curl -N "localhost:9119/annotations/stream?tags[]=deploy-*"
+	tags[] and match[] work like in queries, a stream without them gets every new annotation.
+	annotations are published by the server that stores them, with RethinkDB they come from a changefeed
+	so annotations added through other servers show up as well.
+*/
+
+var (
+	streamKeepalive = flag.Duration("stream-keepalive", 30*time.Second, "Interval of keepalive comments on idle annotation streams")
+)
+
+// ChangeWatcher is implemented by storages that report annotations added by any server using them
+type ChangeWatcher interface {
+	WatchAdded(fn func(a Annotation)) error // blocks until the feed fails or the storage is closed
+}
+
+// broker passes new annotations on to all subscribers
+type broker struct {
+	sync.Mutex
+	subs map[chan Annotation]bool
+}
+
+func newBroker() *broker {
+	return &broker{subs: make(map[chan Annotation]bool)}
+}
+
+func (b *broker) Subscribe() chan Annotation {
+	b.Lock()
+	defer b.Unlock()
+
+	ch := make(chan Annotation, 64)
+	b.subs[ch] = true
+	return ch
+}
+
+func (b *broker) Unsubscribe(ch chan Annotation) {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.subs[ch] {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Publish never blocks, subscribers that can't keep up miss annotations
+func (b *broker) Publish(a Annotation) {
+	b.Lock()
+	defer b.Unlock()
+
+	for ch := range b.subs {
+		select {
+		case ch <- a:
+		default:
+			log.Printf("Stream subscriber too slow, dropped annotation %s", a.ID)
+		}
+	}
+}
+
+// subscription selects the annotations a stream gets, an empty one gets all of them
+type subscription struct {
+	tags      []*regexp.Regexp
+	selectors [][]*LabelMatcher
+}
+
+func newSubscription(tags, selectors []string) (sub subscription, err error) {
+	for _, tag := range tags {
+		sub.tags = append(sub.tags, wildcardRegexp(tag))
+	}
+	for _, sel := range selectors {
+		ms, err := ParseMatchers(sel)
+		if err != nil {
+			return sub, err
+		}
+		sub.selectors = append(sub.selectors, ms)
+	}
+	return sub, nil
+}
+
+// matches reports whether a has one of the tags or matches one of the selectors
+func (sub subscription) matches(a Annotation) bool {
+	if len(sub.tags) == 0 && len(sub.selectors) == 0 {
+		return true
+	}
+	for _, re := range sub.tags {
+		for _, tag := range a.Tags {
+			if re.MatchString(tag) {
+				return true
+			}
+		}
+	}
+	for _, ms := range sub.selectors {
+		if a.MatchesLabels(ms) {
+			return true
+		}
+	}
+	return false
+}
+
+// add stores a and publishes it to streams, unless the storage reports new annotations itself
+func (s *ServerContext) add(a Annotation) (id string, err error) {
+	if id, err = s.storage.Add(a); err != nil {
+		return id, err
+	}
+	if _, ok := s.storage.(ChangeWatcher); !ok {
+		a.ID = id
+		s.broker.Publish(a.inMillis())
+	}
+	return id, nil
+}
+
+// watchStorage feeds the broker from storages that report new annotations
+func (s *ServerContext) watchStorage() {
+	w, ok := s.storage.(ChangeWatcher)
+	if !ok {
+		return
+	}
+	go func() {
+		if err := w.WatchAdded(s.broker.Publish); err != nil {
+			log.Printf("Watching for new annotations failed, streams won't get any, err: %s", err)
+		}
+	}()
+}
+
+func (s *ServerContext) stream(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		http.Error(w, "Not supported", 405)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, 500, map[string]string{"result": "streaming_unsupported"})
+		return
+	}
+	req.ParseForm()
+	sub, err := newSubscription(req.Form["tags[]"], req.Form["match[]"])
+	if err != nil {
+		writeJSON(w, 400, map[string]string{"result": "invalid_matcher", "error": err.Error()})
+		return
+	}
+
+	ch := s.broker.Subscribe()
+	defer s.broker.Unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(*streamKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case a := <-ch:
+			if !sub.matches(a) {
+				continue
+			}
+			data, _ := json.Marshal(a)
+			fmt.Fprintf(w, "id: %s\nevent: annotation\ndata: %s\n\n", a.ID, data)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+		flusher.Flush()
+	}
+}
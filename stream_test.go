@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBroker(t *testing.T) {
+	b := newBroker()
+	ch1, ch2 := b.Subscribe(), b.Subscribe()
+
+	b.Publish(Annotation{ID: "1"})
+	if a := <-ch1; a.ID != "1" {
+		t.Errorf("no good, got %#v", a)
+	}
+	if a := <-ch2; a.ID != "1" {
+		t.Errorf("no good, got %#v", a)
+	}
+
+	b.Unsubscribe(ch1)
+	b.Unsubscribe(ch1)
+	if _, ok := <-ch1; ok {
+		t.Errorf("no good, channel should be closed")
+	}
+
+	// slow subscribers don't block publishing
+	for i := 0; i < 100; i++ {
+		b.Publish(Annotation{ID: fmt.Sprint(i)})
+	}
+	if len(ch2) != cap(ch2) {
+		t.Errorf("no good, expected a full channel, got %d", len(ch2))
+	}
+}
+
+func TestSubscription(t *testing.T) {
+	sub, err := newSubscription([]string{"deploy-*", "build"}, []string{`{env="prod"}`})
+	if err != nil {
+		t.Errorf("no good: %s", err)
+		return
+	}
+	for _, c := range []struct {
+		a        Annotation
+		expected bool
+	}{
+		{Annotation{Tags: []string{"deploy-api"}}, true},
+		{Annotation{Tags: []string{"other", "build"}}, true},
+		{Annotation{Tags: []string{"builds"}}, false},
+		{Annotation{Tags: []string{"other"}, Labels: map[string]string{"env": "prod"}}, true},
+		{Annotation{Tags: []string{"other"}, Labels: map[string]string{"env": "dev"}}, false},
+	} {
+		if sub.matches(c.a) != c.expected {
+			t.Errorf("no good, %#v should return %t", c.a, c.expected)
+		}
+	}
+
+	if sub, _ := newSubscription(nil, nil); !sub.matches(Annotation{Tags: []string{"any"}}) {
+		t.Errorf("no good, empty subscriptions get everything")
+	}
+	if _, err := newSubscription(nil, []string{`{env=~"("}`}); err == nil {
+		t.Errorf("no good, expected error")
+	}
+}
+
+func (s *TestSetup) testStream() {
+	res, err := http.Get(s.Server.URL + "/annotations/stream?tags[]=streamtag*")
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 || res.Header.Get("Content-Type") != "text/event-stream" {
+		s.T.Errorf("Wrong response: %d %s", res.StatusCode, res.Header.Get("Content-Type"))
+		return
+	}
+
+	events := make(chan string)
+	go func() {
+		r := bufio.NewReader(res.Body)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(events)
+				return
+			}
+			if strings.HasPrefix(line, "data: ") {
+				events <- strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}()
+
+	s.put("not streamed", "otherstreamtag", 0)
+	s.put("streamed", "streamtag1", 0)
+
+	select {
+	case data := <-events:
+		var a Annotation
+		if err := json.Unmarshal([]byte(data), &a); err != nil || a.Message != "streamed" || a.ID == "" {
+			s.T.Errorf("err: %s or Wrong event: %s", err, data)
+		}
+	case <-time.After(5 * time.Second):
+		s.T.Errorf("No event received")
+	}
+
+	res, err = http.Get(s.Server.URL + "/annotations/stream?match[]=" + url.QueryEscape(`{env=~"("}`))
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != 400 {
+		s.T.Errorf("Expected code of 400, not: %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+	tags in queries can contain * wildcards and tag_regex selects tags by regular expression:
+		curl "localhost:9119/annotations?tags[]=build-*&tag_regex=^deploy-(api|web)$"
+	patterns are resolved to the existing tags before querying, every pattern may expand to at most --tag-pattern-limit tags.
+*/
+
+var (
+	tagPatternLimit = flag.Int("tag-pattern-limit", 100, "Maximum number of tags a single tag wildcard or regex may expand to")
+)
+
+// TagPatternMatcher is implemented by storages that can find tags matching a regular expression themselves
+type TagPatternMatcher interface {
+	TagsMatching(re *regexp.Regexp) ([]string, error)
+}
+
+// ErrTooManyTags is returned if a pattern expands to more tags than allowed
+type ErrTooManyTags struct {
+	Pattern string
+	Count   int
+	Limit   int
+}
+
+func (e ErrTooManyTags) Error() string {
+	return fmt.Sprintf("pattern %s matches %d tags, the limit is %d", e.Pattern, e.Count, e.Limit)
+}
+
+// wildcardRegexp turns a tag with * wildcards into an anchored regular expression
+func wildcardRegexp(tag string) *regexp.Regexp {
+	parts := strings.Split(tag, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// ExpandTags resolves wildcard tags and regexes to the matching tags, tags without wildcards are kept as they are.
+// the result is free of duplicates.
+func ExpandTags(s Storage, tags []string, regexes []*regexp.Regexp, limit int) (res []string, err error) {
+	type pattern struct {
+		src string
+		re  *regexp.Regexp
+	}
+	var patterns []pattern
+
+	seen := make(map[string]bool)
+	add := func(tag string) {
+		if !seen[tag] {
+			seen[tag] = true
+			res = append(res, tag)
+		}
+	}
+	for _, tag := range tags {
+		if strings.Contains(tag, "*") {
+			patterns = append(patterns, pattern{tag, wildcardRegexp(tag)})
+			continue
+		}
+		add(tag)
+	}
+	for _, re := range regexes {
+		patterns = append(patterns, pattern{re.String(), re})
+	}
+	if len(patterns) == 0 {
+		return res, nil
+	}
+
+	var all []string
+	if _, ok := s.(TagPatternMatcher); !ok {
+		all = s.AllTags()
+	}
+	for _, p := range patterns {
+		var matched []string
+		if m, ok := s.(TagPatternMatcher); ok {
+			if matched, err = m.TagsMatching(p.re); err != nil {
+				return nil, err
+			}
+		} else {
+			for _, tag := range all {
+				if p.re.MatchString(tag) {
+					matched = append(matched, tag)
+				}
+			}
+		}
+		if limit > 0 && len(matched) > limit {
+			return nil, ErrTooManyTags{Pattern: p.src, Count: len(matched), Limit: limit}
+		}
+		sort.Strings(matched)
+		for _, tag := range matched {
+			add(tag)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestExpandTags(t *testing.T) {
+	s := NewMemoryStorage()
+	defer s.Cleanup()
+	for _, tag := range []string{"build-api", "build-web", "deploy-api", "deploy-web", "deploy-db", "other"} {
+		s.Add(Annotation{CreatedAt: 1, Message: "msg", Tags: []string{tag}})
+	}
+
+	for _, tc := range []struct {
+		tags     []string
+		regexes  []string
+		expected []string
+	}{
+		{[]string{"other", "build-*"}, nil, []string{"other", "build-api", "build-web"}},
+		{[]string{"*-api"}, nil, []string{"build-api", "deploy-api"}},
+		{nil, []string{"^deploy-(api|web)$"}, []string{"deploy-api", "deploy-web"}},
+		{[]string{"deploy-api", "deploy-*"}, nil, []string{"deploy-api", "deploy-db", "deploy-web"}},
+		{[]string{"does-not-exist", "nothing-*"}, nil, []string{"does-not-exist"}},
+		// regex meta characters in wildcard tags are literals
+		{[]string{"build.*"}, nil, nil},
+	} {
+		var regexes []*regexp.Regexp
+		for _, expr := range tc.regexes {
+			regexes = append(regexes, regexp.MustCompile(expr))
+		}
+		res, err := ExpandTags(s, tc.tags, regexes, 10)
+		if err != nil || !reflect.DeepEqual(res, tc.expected) {
+			t.Errorf("no good, %v %v expanded to %v, err: %s", tc.tags, tc.regexes, res, err)
+		}
+	}
+
+	if _, err := ExpandTags(s, []string{"*"}, nil, 5); err == nil {
+		t.Errorf("no good, expected error for too many tags")
+	}
+}
+
+func (s *TestSetup) testTagPatterns() {
+	ts := int(time.Now().Unix())
+	for _, tag := range []string{"patternbuild-api", "patternbuild-web", "patterndeploy-api"} {
+		if err := s.put("msg", tag, ts); err != nil {
+			s.T.Error(err)
+		}
+	}
+
+	if l, err := s.queryURL(fmt.Sprintf("%s/annotations?until=%d&tags[]=patternbuild-*", s.Server.URL, ts)); err != nil || len(l.Posts) != 2 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	if l, err := s.queryURL(fmt.Sprintf("%s/annotations?until=%d&tag_regex=%s", s.Server.URL, ts, url.QueryEscape("^pattern[a-z]+-api$"))); err != nil || len(l.Posts) != 2 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+
+	defer func(limit int) { *tagPatternLimit = limit }(*tagPatternLimit)
+	*tagPatternLimit = 2
+	for _, q := range []string{"tag_regex=" + url.QueryEscape("(unclosed"), "tags[]=*"} {
+		res, err := http.Get(fmt.Sprintf("%s/annotations?%s", s.Server.URL, q))
+		if err != nil {
+			s.T.Errorf("err: %s", err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != 400 {
+			s.T.Errorf("Expected code of 400 for %s, not: %d", q, res.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+/*
+	tag management:
+		curl "localhost:9119/tags"
+		curl -XPOST -d '{"to": "build-web"}'  "localhost:9119/tags/build/rename"
+		curl -XPOST -d '{"into": "build"}'  "localhost:9119/tags/build-prod/merge"
+		curl -XDELETE "localhost:9119/tags/build"
+*/
+
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
+}
+
+type tagsRequest struct {
+	To   string `json:"to"`
+	Into string `json:"into"`
+}
+
+func (s *ServerContext) tags(w http.ResponseWriter, req *http.Request) {
+
+	path := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, *tagsEndpoint), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "" && req.Method == "GET":
+		s.listTags(w, req)
+
+	case len(parts) == 1 && req.Method == "DELETE":
+		s.deleteTag(w, req, parts[0])
+
+	case len(parts) == 2 && parts[1] == "rename" && req.Method == "POST":
+		s.renameTag(w, req, parts[0], false)
+
+	case len(parts) == 2 && parts[1] == "merge" && req.Method == "POST":
+		s.renameTag(w, req, parts[0], true)
+
+	default:
+		http.Error(w, "Not supported", 405)
+	}
+}
+
+func (s *ServerContext) listTags(w http.ResponseWriter, req *http.Request) {
+
+	stats, err := s.storage.TagStats()
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+		return
+	}
+
+	list := make([]TagCount, 0, len(stats))
+	for tag, count := range stats {
+		list = append(list, TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Tag < list[j].Tag })
+
+	writeJSON(w, 200, map[string][]TagCount{"tags": list})
+}
+
+func hasTag(s Storage, tag string) bool {
+	for _, t := range s.AllTags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// renameTag handles both rename and merge, the only difference being
+// whether the target tag has to exist already or must not exist yet
+// checkReservedTags rejects tags with the prefix of the BoltDB storage's internal buckets
+func checkReservedTags(tags []string) error {
+	for _, tag := range tags {
+		if strings.HasPrefix(tag, boltReservedPrefix) {
+			return fmt.Errorf("invalid tag \"%s\", prefix %s is reserved", tag, boltReservedPrefix)
+		}
+	}
+	return nil
+}
+
+func (s *ServerContext) renameTag(w http.ResponseWriter, req *http.Request, from string, merge bool) {
+
+	defer req.Body.Close()
+	body, _ := ioutil.ReadAll(req.Body)
+	var r tagsRequest
+	if err := json.Unmarshal(body, &r); err != nil {
+		log.Printf("unmarshal tags request error: %s", body)
+		writeJSON(w, 400, map[string]string{"result": "invalid_json"})
+		return
+	}
+
+	to := r.To
+	if merge {
+		to = r.Into
+	}
+	if to == "" || strings.Contains(to, "/") {
+		writeJSON(w, 400, map[string]string{"result": "invalid_tag"})
+		return
+	}
+	if err := checkReservedTags([]string{to}); err != nil {
+		writeJSON(w, 400, map[string]string{"result": "invalid_tag", "error": err.Error()})
+		return
+	}
+
+	if exists := hasTag(s.storage, to); exists != merge {
+		if merge {
+			writeJSON(w, 404, map[string]string{"result": "not_found"})
+		} else {
+			writeJSON(w, 409, map[string]string{"result": "tag_exists"})
+		}
+		return
+	}
+
+	err := s.storage.RenameTag(from, to)
+	switch err {
+	case nil:
+		writeJSON(w, 200, map[string]string{"result": "ok"})
+	case ErrTagNotFound:
+		writeJSON(w, 404, map[string]string{"result": "not_found"})
+	default:
+		log.Printf("rename tag %s to %s err: %s", from, to, err)
+		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+	}
+}
+
+func (s *ServerContext) deleteTag(w http.ResponseWriter, req *http.Request, tag string) {
+
+	count, err := s.storage.DeleteTag(tag)
+	switch err {
+	case nil:
+		writeJSON(w, 200, map[string]interface{}{"result": "ok", "deleted": count})
+	case ErrTagNotFound:
+		writeJSON(w, 404, map[string]string{"result": "not_found"})
+	default:
+		log.Printf("delete tag %s err: %s", tag, err)
+		writeJSON(w, 500, map[string]string{"result": fmt.Sprintf("err: %s", err)})
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+func (s *TestSetup) tagsRequest(method, path, body string, expectedStatus int) {
+	request, _ := http.NewRequest(method, s.Server.URL+*tagsEndpoint+path, strings.NewReader(body))
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode != expectedStatus {
+		s.T.Errorf("%s %s: Expected code of %d, not: %d", method, path, expectedStatus, res.StatusCode)
+	}
+}
+
+func (s *TestSetup) tagCounts() map[string]int {
+	res, err := http.Get(s.Server.URL + *tagsEndpoint)
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return nil
+	}
+	defer res.Body.Close()
+
+	var list map[string][]TagCount
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		s.T.Errorf("err: %s", err)
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, tc := range list["tags"] {
+		counts[tc.Tag] = tc.Count
+	}
+	return counts
+}
+
+func (s *TestSetup) testTags() {
+	ts := int(time.Now().Unix())
+	s.put("msg1", "tagmgmt1", ts)
+	s.put("msg2", "tagmgmt1", ts)
+	s.put("msg3", "tagmgmt2", ts)
+	s.putJSON(fmt.Sprintf(`{"created_at": %d, "message": "msg4", "tags": ["tagmgmt2", "tagmgmt3"]}`, ts), 200)
+
+	if counts := s.tagCounts(); counts["tagmgmt1"] != 2 || counts["tagmgmt2"] != 2 || counts["tagmgmt3"] != 1 {
+		s.T.Errorf("wrong tag counts: %#v", counts)
+	}
+
+	s.tagsRequest("POST", "/tagmgmt1/rename", `{"to": "tagmgmt2"}`, 409)
+	s.tagsRequest("POST", "/tagmgmt1/rename", `{"to": ""}`, 400)
+	s.tagsRequest("POST", "/tagmgmt1/rename", `{"to": "__anno_all"}`, 400)
+	if err := s.putJSON(`{"message": "reserved", "tags": ["__anno_ids"]}`, 400); err != nil {
+		s.T.Error(err)
+	}
+	s.tagsRequest("POST", "/tagmgmt1/rename", `{ BROKEN_JSON }`, 400)
+	s.tagsRequest("POST", "/does-not-exist/rename", `{"to": "tagmgmt4"}`, 404)
+	s.tagsRequest("POST", "/tagmgmt1/rename", `{"to": "tagmgmt4"}`, 200)
+	s.tagsRequest("POST", "/tagmgmt4/merge", `{"into": "does-not-exist"}`, 404)
+	s.tagsRequest("POST", "/tagmgmt4/merge", `{"into": "tagmgmt2"}`, 200)
+
+	if counts := s.tagCounts(); counts["tagmgmt1"] != 0 || counts["tagmgmt4"] != 0 || counts["tagmgmt2"] != 4 {
+		s.T.Errorf("wrong tag counts: %#v", counts)
+	}
+
+	s.tagsRequest("DELETE", "/tagmgmt3", "", 200)
+	s.tagsRequest("DELETE", "/tagmgmt3", "", 404)
+
+	if counts := s.tagCounts(); counts["tagmgmt2"] != 3 || counts["tagmgmt3"] != 0 {
+		s.T.Errorf("wrong tag counts: %#v", counts)
+	}
+
+	s.tagsRequest("DELETE", "/tagmgmt2", "", 200)
+	if l, err := s.query("tagmgmt2", ts); err != nil || len(l.Posts) != 0 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+	queries accept human-friendly times:
+		curl "localhost:9119/annotations?tags[]=deploy&range=7d"
+		curl "localhost:9119/annotations?tags[]=deploy&from=now-2d&until=now-1d"
+		curl "localhost:9119/annotations?tags[]=deploy&from=2015-05-05T00:00:00Z&until=2015-05-06T00:00:00Z"
+	range takes seconds or durations like 90m, 1h30m, 7d or 1w, until and from take Unix seconds or
+	milliseconds, RFC3339 or now-<duration>. created_at and ends_at on PUT can be seconds or milliseconds.
+*/
+
+var durationRE = regexp.MustCompile(`^(?:[0-9]+[ywdhms])+$`)
+
+var durationUnits = map[byte]int{
+	'y': 365 * 24 * 3600,
+	'w': 7 * 24 * 3600,
+	'd': 24 * 3600,
+	'h': 3600,
+	'm': 60,
+	's': 1,
+}
+
+// ParseDuration parses plain seconds or Prometheus-style durations like 6h or 1d12h into seconds
+func ParseDuration(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid duration %s, it has to be positive", s)
+		}
+		return n, nil
+	}
+	if !durationRE.MatchString(s) {
+		return 0, fmt.Errorf("invalid duration %s, use seconds or e.g. 90m, 6h, 7d", s)
+	}
+	res, n := 0, 0
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= '0' && c <= '9' {
+			n = n*10 + int(c-'0')
+		} else {
+			res += n * durationUnits[c]
+			n = 0
+		}
+	}
+	if res <= 0 {
+		return 0, fmt.Errorf("invalid duration %s, it has to be positive", s)
+	}
+	return res, nil
+}
+
+// EpochSeconds tells seconds from milliseconds since the epoch, seconds are accepted up to the year 2286
+// and milliseconds from 1973 on. Values in between could be either and are rejected.
+func EpochSeconds(v int) (int, error) {
+	switch {
+	case v < 0:
+		return 0, fmt.Errorf("invalid timestamp %d, it must not be negative", v)
+	case v < 1e10:
+		return v, nil
+	case v >= 1e11 && v < 1e13:
+		return v / 1000, nil
+	}
+	return 0, fmt.Errorf("ambiguous timestamp %d, use seconds or milliseconds since the epoch", v)
+}
+
+// ParseTime parses epoch seconds or milliseconds, RFC3339 and now, now-<duration> or now+<duration>
+func ParseTime(s string, now time.Time) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return EpochSeconds(n)
+	}
+	if strings.HasPrefix(s, "now") {
+		rest := s[len("now"):]
+		if rest == "" {
+			return int(now.Unix()), nil
+		}
+		d, err := ParseDuration(rest[1:])
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %s: %s", s, err)
+		}
+		switch rest[0] {
+		case '-':
+			return int(now.Unix()) - d, nil
+		case '+':
+			return int(now.Unix()) + d, nil
+		}
+		return 0, fmt.Errorf("invalid time %s, use now-<duration>", s)
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %s, use Unix seconds, RFC3339 or now-<duration>", s)
+	}
+	return int(t.Unix()), nil
+}
+
+// ParseQueryRange reads range, until and from of a query, range defaults to an hour and until to now.
+// from is an alternative to range and can't be combined with it.
+func ParseQueryRange(form url.Values, now time.Time) (r, until int, err error) {
+	until = int(now.Unix())
+	if v := form.Get("until"); v != "" {
+		if until, err = ParseTime(v, now); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	from, rng := form.Get("from"), form.Get("range")
+	switch {
+	case from != "" && rng != "":
+		return 0, 0, fmt.Errorf("use either from or range")
+	case from != "":
+		start, err := ParseTime(from, now)
+		if err != nil {
+			return 0, 0, err
+		}
+		if start >= until {
+			return 0, 0, fmt.Errorf("from %s has to be before until", from)
+		}
+		return until - start, until, nil
+	case rng != "":
+		r, err = ParseDuration(rng)
+		return r, until, err
+	}
+	return 3600, until, nil
+}
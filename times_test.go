@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	for s, expected := range map[string]int{
+		"3600":  3600,
+		"90m":   5400,
+		"6h":    21600,
+		"1h30m": 5400,
+		"7d":    604800,
+		"1w":    604800,
+		"1y":    31536000,
+	} {
+		if d, err := ParseDuration(s); err != nil || d != expected {
+			t.Errorf("no good, %s: err: %s duration: %d", s, err, d)
+		}
+	}
+	for _, s := range []string{"", "0", "-5", "0h", "h", "6x", "1.5h", "6h ago"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("no good, expected error for %s", s)
+		}
+	}
+}
+
+func TestEpochSeconds(t *testing.T) {
+	for v, expected := range map[int]int{
+		1430797123:    1430797123,
+		1430797123456: 1430797123,
+		0:             0,
+	} {
+		if s, err := EpochSeconds(v); err != nil || s != expected {
+			t.Errorf("no good, %d: err: %s seconds: %d", v, err, s)
+		}
+	}
+	for _, v := range []int{-1, 14307971234, 14307971234567} {
+		if _, err := EpochSeconds(v); err == nil {
+			t.Errorf("no good, expected error for %d", v)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Unix(1430797123, 0)
+	for s, expected := range map[string]int{
+		"1430797123":           1430797123,
+		"1430797123000":        1430797123,
+		"2015-05-05T03:38:43Z": 1430797123,
+		"now":                  1430797123,
+		"now-1h":               1430793523,
+		"now+1d":               1430883523,
+	} {
+		if ts, err := ParseTime(s, now); err != nil || ts != expected {
+			t.Errorf("no good, %s: err: %s time: %d", s, err, ts)
+		}
+	}
+	for _, s := range []string{"yesterday", "now-", "now*1h", "now-1x", "2015-05-05", "14307971234"} {
+		if _, err := ParseTime(s, now); err == nil {
+			t.Errorf("no good, expected error for %s", s)
+		}
+	}
+}
+
+func TestParseQueryRange(t *testing.T) {
+	now := time.Unix(1430797123, 0)
+	for q, expected := range map[string][2]int{
+		"":                          {3600, 1430797123},
+		"range=6h":                  {21600, 1430797123},
+		"range=60&until=1430790000": {60, 1430790000},
+		"from=now-2d&until=now-1d":  {86400, 1430710723},
+		"from=2015-05-05T00:00:00Z": {1430797123 - 1430784000, 1430797123},
+	} {
+		form, _ := url.ParseQuery(q)
+		r, until, err := ParseQueryRange(form, now)
+		if err != nil || r != expected[0] || until != expected[1] {
+			t.Errorf("no good, %s: err: %s range: %d until: %d", q, err, r, until)
+		}
+	}
+	for _, q := range []string{"range=soon", "until=later", "from=now-1h&range=1h", "from=now&until=now-1h"} {
+		form, _ := url.ParseQuery(q)
+		if _, _, err := ParseQueryRange(form, now); err == nil {
+			t.Errorf("no good, expected error for %s", q)
+		}
+	}
+}
+
+func (s *TestSetup) testTimes() {
+	ts := int(time.Now().Unix())
+	if err := s.putJSON(fmt.Sprintf(`{"created_at": %d, "message": "millis", "tags": ["timetag"]}`, (ts-120)*1000), 200); err != nil {
+		s.T.Error(err)
+	}
+	if err := s.putJSON(fmt.Sprintf(`{"created_at": %d, "message": "ambiguous", "tags": ["timetag"]}`, ts*10), 400); err != nil {
+		s.T.Error(err)
+	}
+
+	l, err := s.queryURL(fmt.Sprintf("%s/annotations?tags[]=timetag&range=5m", s.Server.URL))
+	if err != nil || len(l.Posts) != 1 || l.Posts[0].CreatedAt != (ts-120)*1000 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	l, err = s.queryURL(fmt.Sprintf("%s/annotations?tags[]=timetag&from=now-1h&until=now-1m", s.Server.URL))
+	if err != nil || len(l.Posts) != 1 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+	l, err = s.queryURL(fmt.Sprintf("%s/annotations?tags[]=timetag&range=1m", s.Server.URL))
+	if err != nil || len(l.Posts) != 0 {
+		s.T.Errorf("err: %s or Wrong l.Posts: %#v", err, l.Posts)
+	}
+
+	for _, q := range []string{"range=soon", "until=yesterday", "from=now-1h&range=1h"} {
+		res, err := http.Get(fmt.Sprintf("%s/annotations?tags[]=timetag&%s", s.Server.URL, q))
+		if err != nil {
+			s.T.Errorf("err: %s", err)
+			return
+		}
+		res.Body.Close()
+		if res.StatusCode != 400 {
+			s.T.Errorf("Expected code of 400 for %s, not: %d", q, res.StatusCode)
+		}
+	}
+}
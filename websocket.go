@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+/*
+	dashboards can follow many panels over a single WebSocket at ws://localhost:9119/annotations/ws
+	the client sends a subscription, tags can contain wildcards and match holds label selectors:
+		{"id": "1", "tags": ["deploy-*"], "match": ["{env=\"prod\"}"], "range": "6h"}
+	it gets the matching annotations of the last range (default 1h) as backfill, followed by new ones:
+		{"type": "backfill", "id": "1", "posts": [...]}
+		{"type": "annotation", "annotation": {...}}
+	the backfill holds at most the newest wsBackfillLimit annotations and subscriptions may be at most
+	wsReadLimit bytes long, larger ones close the connection.
+	every subscription replaces the previous one, an empty one gets all annotations. invalid ones are
+	answered with {"type": "error", "id": "1", "error": "..."} and the previous subscription stays active.
+	an annotation added while the backfill is read can be sent twice.
+*/
+
+var (
+	wsOrigins = flag.String("websocket-origins", "", "Comma separated origins allowed to open WebSockets besides the server's own, * allows all")
+)
+
+var wsUpgrader = websocket.Upgrader{CheckOrigin: checkWSOrigin}
+
+var wsBackfillLimit = 1000
+
+const wsReadLimit = 4096
+
+// checkWSOrigin allows requests without Origin, from the same host and from the configured origins
+func checkWSOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range splitList(*wsOrigins) {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == req.Host
+}
+
+type wsRequest struct {
+	ID    string   `json:"id"`
+	Tags  []string `json:"tags"`
+	Match []string `json:"match"`
+	Range string   `json:"range"`
+
+	err error // reading the request failed
+}
+
+// backfill returns the newest wsBackfillLimit annotations of the last r seconds matching the request, each once and oldest first
+func (s *ServerContext) backfill(req wsRequest, sub subscription, r int) ([]Annotation, error) {
+	until := int(time.Now().Unix())
+	newest := ListOpts{Desc: true, Limit: wsBackfillLimit}
+	if len(req.Tags) == 0 && len(req.Match) == 0 {
+		// untagged annotations only show up without filtering by tags
+		list := make([]Annotation, 0)
+		if err := s.storage.List(r, until, newest, &list); err != nil {
+			return nil, err
+		}
+		return oldestFirst(list), nil
+	}
+
+	tags, err := ExpandTags(s.storage, req.Tags, nil, *tagPatternLimit)
+	if err != nil {
+		return nil, err
+	}
+	list, err := GetPostsForTags(s.storage, tags, TagOr, r, until)
+	if err != nil {
+		return nil, err
+	}
+	matched, err := GetPostsForMatchers(s.storage, sub.selectors, r, until)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, a := range list.Posts {
+		seen[a.ID] = true
+	}
+	for _, a := range matched {
+		if !seen[a.ID] {
+			list.Posts = append(list.Posts, a)
+		}
+	}
+	posts, _ := Paginate(list.Posts, newest)
+	return oldestFirst(posts), nil
+}
+
+// oldestFirst reverses a list ordered newest first
+func oldestFirst(list []Annotation) []Annotation {
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return list
+}
+
+func (s *ServerContext) ws(w http.ResponseWriter, req *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// the upgrader already responded
+		log.Printf("WebSocket upgrade failed, err: %s", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(wsReadLimit)
+
+	// only this goroutine writes, the reader hands over the requests
+	requests := make(chan wsRequest)
+	quit := make(chan struct{})
+	defer close(quit)
+	go func() {
+		defer close(requests)
+		for {
+			_, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var r wsRequest
+			if err := json.Unmarshal(msg, &r); err != nil {
+				r.err = err
+			}
+			select {
+			case requests <- r:
+			case <-quit:
+				return
+			}
+		}
+	}()
+
+	ch := s.broker.Subscribe()
+	defer s.broker.Unsubscribe(ch)
+	ping := time.NewTicker(*streamKeepalive)
+	defer ping.Stop()
+
+	var sub *subscription
+	for {
+		select {
+		case r, ok := <-requests:
+			if !ok {
+				return
+			}
+			err = s.subscribe(conn, r, &sub)
+		case a := <-ch:
+			if sub != nil && sub.matches(a) {
+				err = conn.WriteJSON(map[string]interface{}{"type": "annotation", "annotation": a})
+			}
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second))
+		}
+		if err != nil {
+			log.Printf("WebSocket closed, err: %s", err)
+			return
+		}
+	}
+}
+
+// subscribe answers r with the backfill and makes it the current subscription, or with an error
+func (s *ServerContext) subscribe(conn *websocket.Conn, r wsRequest, current **subscription) error {
+	fail := func(err error) error {
+		return conn.WriteJSON(map[string]string{"type": "error", "id": r.ID, "error": err.Error()})
+	}
+	if r.err != nil {
+		return fail(r.err)
+	}
+	sub, err := newSubscription(r.Tags, r.Match)
+	if err != nil {
+		return fail(err)
+	}
+	rng := 3600
+	if r.Range != "" {
+		if rng, err = ParseDuration(r.Range); err != nil {
+			return fail(err)
+		}
+	}
+	posts, err := s.backfill(r, sub, rng)
+	if err != nil {
+		return fail(err)
+	}
+	*current = &sub
+	return conn.WriteJSON(map[string]interface{}{"type": "backfill", "id": r.ID, "posts": posts})
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCheckWSOrigin(t *testing.T) {
+	defer func(o string) { *wsOrigins = o }(*wsOrigins)
+	*wsOrigins = "https://grafana.example.com"
+
+	for origin, expected := range map[string]bool{
+		"":                            true,
+		"http://localhost:9119":       true,
+		"https://grafana.example.com": true,
+		"https://evil.example.com":    false,
+	} {
+		req, _ := http.NewRequest("GET", "http://localhost:9119/annotations/ws", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if checkWSOrigin(req) != expected {
+			t.Errorf("no good, %s should return %t", origin, expected)
+		}
+	}
+
+	*wsOrigins = "*"
+	req, _ := http.NewRequest("GET", "http://localhost:9119/annotations/ws", nil)
+	req.Header.Set("Origin", "https://evil.example.com")
+	if !checkWSOrigin(req) {
+		t.Errorf("no good, * should allow all origins")
+	}
+}
+
+type wsTestMessage struct {
+	Type       string
+	ID         string
+	Posts      []Annotation
+	Annotation Annotation
+	Error      string
+}
+
+func (s *TestSetup) readWS(conn *websocket.Conn) (m wsTestMessage, err error) {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	err = conn.ReadJSON(&m)
+	return
+}
+
+func (s *TestSetup) testWebSocket() {
+	ts := int(time.Now().Unix())
+	if err := s.putJSON(fmt.Sprintf(`{"created_at": %d, "message": "ws old", "tags": ["wstag1"]}`, ts-60), 200); err != nil {
+		s.T.Error(err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.Server.URL, "http")+"/annotations/ws", nil)
+	if err != nil {
+		s.T.Errorf("err: %s", err)
+		return
+	}
+	defer conn.Close()
+
+	conn.WriteJSON(map[string]interface{}{"id": "1", "tags": []string{"wstag*"}, "range": "5m"})
+	m, err := s.readWS(conn)
+	if err != nil || m.Type != "backfill" || m.ID != "1" || len(m.Posts) != 1 || m.Posts[0].Message != "ws old" {
+		s.T.Errorf("err: %s or Wrong message: %#v", err, m)
+		return
+	}
+
+	s.put("ws other", "otherwstag", 0)
+	s.put("ws new", "wstag2", 0)
+	if m, err = s.readWS(conn); err != nil || m.Type != "annotation" || m.Annotation.Message != "ws new" {
+		s.T.Errorf("err: %s or Wrong message: %#v", err, m)
+	}
+
+	// invalid subscriptions keep the previous one
+	conn.WriteJSON(map[string]interface{}{"id": "2", "match": []string{`{team=~"("}`}})
+	if m, err = s.readWS(conn); err != nil || m.Type != "error" || m.ID != "2" || m.Error == "" {
+		s.T.Errorf("err: %s or Wrong message: %#v", err, m)
+	}
+
+	conn.WriteJSON(map[string]interface{}{"id": "3", "match": []string{`{team="wsteam"}`}})
+	if m, err = s.readWS(conn); err != nil || m.Type != "backfill" || m.ID != "3" || len(m.Posts) != 0 {
+		s.T.Errorf("err: %s or Wrong message: %#v", err, m)
+	}
+	s.put("ws not subscribed anymore", "wstag3", 0)
+	if err := s.putJSON(`{"message": "ws team", "tags": ["teamtag"], "labels": {"team": "wsteam"}}`, 200); err != nil {
+		s.T.Error(err)
+	}
+	if m, err = s.readWS(conn); err != nil || m.Type != "annotation" || m.Annotation.Message != "ws team" {
+		s.T.Errorf("err: %s or Wrong message: %#v", err, m)
+	}
+
+	// empty subscriptions get untagged annotations as well
+	if err := s.putJSON(fmt.Sprintf(`{"created_at": %d, "message": "ws untagged"}`, ts-30), 200); err != nil {
+		s.T.Error(err)
+	}
+	conn.WriteJSON(map[string]interface{}{"id": "4", "range": "5m"})
+	if m, err = s.readWS(conn); err != nil || m.Type != "backfill" || m.ID != "4" {
+		s.T.Errorf("err: %s or Wrong message: %#v", err, m)
+		return
+	}
+	found := false
+	for _, a := range m.Posts {
+		found = found || a.Message == "ws untagged"
+	}
+	if !found {
+		s.T.Errorf("Wrong backfill: %#v", m.Posts)
+	}
+
+	// the backfill is capped to the newest annotations, still oldest first
+	defer func(l int) { wsBackfillLimit = l }(wsBackfillLimit)
+	wsBackfillLimit = 2
+	s.put("ws cap 1", "wscap", ts-20)
+	s.put("ws cap 2", "wscap", ts-10)
+	s.put("ws cap 3", "wscap", ts-5)
+	// the empty subscription gets them as well
+	for i := 0; i < 3; i++ {
+		if m, err = s.readWS(conn); err != nil || m.Type != "annotation" {
+			s.T.Errorf("err: %s or Wrong message: %#v", err, m)
+		}
+	}
+	conn.WriteJSON(map[string]interface{}{"id": "5", "tags": []string{"wscap"}, "range": "5m"})
+	if m, err = s.readWS(conn); err != nil || len(m.Posts) != 2 || m.Posts[0].Message != "ws cap 2" || m.Posts[1].Message != "ws cap 3" {
+		s.T.Errorf("err: %s or Wrong message: %#v", err, m)
+	}
+	conn.WriteJSON(map[string]interface{}{"id": "6", "range": "5m"})
+	if m, err = s.readWS(conn); err != nil || len(m.Posts) != 2 || m.Posts[0].CreatedAt > m.Posts[1].CreatedAt {
+		s.T.Errorf("err: %s or Wrong message: %#v", err, m)
+	}
+
+	// subscriptions larger than the read limit close the connection
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"id": "7", "tags": ["`+strings.Repeat("x", wsReadLimit)+`"]}`))
+	if m, err = s.readWS(conn); err == nil {
+		s.T.Errorf("Expected the connection to be closed, got: %#v", m)
+	}
+}